@@ -0,0 +1,167 @@
+package chat
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// runBatch sends every *.json and *.jsonc input file under cfg.BatchDir as an
+// independent conversation, and writes each resulting conversation to the
+// same relative path under the batch output directory. Up to cfg.Concurrency
+// files are sent at a time, sharing the client. A file that fails is reported
+// and skipped without aborting the rest of the batch, and the summary lists
+// the failures in input order, whatever order the files completed in.
+func runBatch(out io.Writer, client *http.Client, cfg *Config) error {
+	inputFiles, err := findBatchFiles(cfg.BatchDir)
+	if err != nil {
+		return err
+	}
+	if len(inputFiles) == 0 {
+		return fmt.Errorf("no *.json or *.jsonc files found in %s", cfg.BatchDir)
+	}
+
+	outputDir := cfg.BatchOutput
+	if outputDir == "" {
+		outputDir = filepath.Join(cfg.LogsDir, "batch", time.Now().UTC().Format(logTimestampFormat))
+	}
+
+	results := make([]batchResult, len(inputFiles))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	var progressMu sync.Mutex
+	done := 0
+
+	for range min(cfg.Concurrency, len(inputFiles)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				start := time.Now()
+				outputFile, err := runBatchFile(out, client, cfg, inputFiles[i], outputDir)
+				results[i] = batchResult{outputFile: outputFile, duration: time.Since(start), err: err}
+
+				progressMu.Lock()
+				done++
+				printBatchProgress(out, done, len(inputFiles), inputFiles[i], results[i])
+				progressMu.Unlock()
+			}
+		}()
+	}
+
+	for i := range inputFiles {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	failures := 0
+	for i, result := range results {
+		if result.err != nil {
+			failures++
+			emitEvent(out, "error", map[string]string{"message": result.err.Error(), "file": inputFiles[i]})
+		}
+	}
+
+	fmt.Fprintf(out, "\nProcessed %d files: %d succeeded, %d failed\n", len(inputFiles), len(inputFiles)-failures, failures)
+	if failures > 0 {
+		for i, result := range results {
+			if result.err != nil {
+				fmt.Fprintf(out, "  %s: %v\n", inputFiles[i], result.err)
+			}
+		}
+		return fmt.Errorf("%d of %d files failed", failures, len(inputFiles))
+	}
+	return nil
+}
+
+type batchResult struct {
+	outputFile string
+	duration   time.Duration
+	err        error
+}
+
+func printBatchProgress(out io.Writer, done int, total int, inputFile string, result batchResult) {
+	if result.err != nil {
+		fmt.Fprintf(out, "[%d/%d] %s ... failed: %v\n", done, total, inputFile, result.err)
+		return
+	}
+	fmt.Fprintf(out, "[%d/%d] %s ... ok (%.1fs) -> %s\n", done, total, inputFile, result.duration.Seconds(), result.outputFile)
+}
+
+// findBatchFiles returns the *.json and *.jsonc files under dir, relative to
+// it and in lexical order.
+func findBatchFiles(dir string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.IsDir() && isInputFile(name) {
+			relative, err := filepath.Rel(dir, name)
+			if err != nil {
+				return err
+			}
+			files = append(files, relative)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list batch files: %w", err)
+	}
+
+	return files, nil
+}
+
+func runBatchFile(out io.Writer, client *http.Client, cfg *Config, inputFile string, outputDir string) (string, error) {
+	inputData, err := readInputFile(filepath.Join(cfg.BatchDir, inputFile), cfg.MaxInputBytes)
+	if err != nil {
+		return "", err
+	}
+
+	messagesIn, err := parseInputMessages(inputFile, inputData)
+	if err != nil {
+		return "", fmt.Errorf("invalid JSON input: %w", err)
+	}
+
+	messages, _, err := loadMessages(out, cfg, messagesIn)
+	if err != nil {
+		return "", err
+	}
+	if len(messages) == 0 || messages[len(messages)-1].Role != USER {
+		return "", fmt.Errorf("the conversation must end with a user message")
+	}
+
+	payload := RequestPayload{
+		Model:       cfg.Model,
+		Messages:    messages,
+		Temperature: float32(cfg.Temperature),
+	}
+	assistantMessage, usage, err := completeConversation(out, client, cfg, payload)
+	if err != nil {
+		return "", err
+	}
+	emitEvent(out, "response", map[string]any{"message": assistantMessage, "file": inputFile})
+	emitEvent(out, "usage", usage)
+
+	fileContent, err := marshalConversation(append(messages, assistantMessage), cfg.ExportFormat)
+	if err != nil {
+		return "", fmt.Errorf("failed to JSON parse conversation content: %w", err)
+	}
+
+	outputFile := filepath.Join(outputDir, strings.TrimSuffix(inputFile, filepath.Ext(inputFile))+logFileSuffix(cfg.ExportFormat))
+	if err := os.MkdirAll(filepath.Dir(outputFile), 0755); err != nil {
+		return "", fmt.Errorf("failed to create output directory: %w", err)
+	}
+	if err := os.WriteFile(outputFile, fileContent, 0644); err != nil {
+		return "", fmt.Errorf("failed to save conversation: %w", err)
+	}
+
+	return outputFile, nil
+}
@@ -0,0 +1,156 @@
+package chat
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Cassette is a recorded HTTP interaction, saved by --record and served back
+// by --replay-http. Request headers aren't recorded, so credentials never end
+// up on disk.
+type Cassette struct {
+	Request struct {
+		Method string          `json:"method"`
+		URL    string          `json:"url"`
+		Body   json.RawMessage `json:"body,omitempty"`
+	} `json:"request"`
+	Response struct {
+		StatusCode  int    `json:"status_code"`
+		ContentType string `json:"content_type"`
+		Body        string `json:"body"`
+	} `json:"response"`
+}
+
+// cassetteKey identifies a request by the hash of its method, URL path and
+// body, so a replayed session gets the recorded response for the same
+// payload. The host is left out so recordings can be replayed against any
+// endpoint.
+func cassetteKey(req *http.Request, body []byte) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s %s\n", req.Method, req.URL.Path)
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))[:16]
+}
+
+func readRequestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil {
+		return nil, nil
+	}
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("error reading request body: %w", err)
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// recordingTransport saves every interaction in dir as it is sent through
+// next.
+type recordingTransport struct {
+	dir  string
+	next http.RoundTripper
+	// out receives the warnings about the interactions that can't be
+	// recorded.
+	out io.Writer
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	cassette := &Cassette{}
+	cassette.Request.Method = req.Method
+	cassette.Request.URL = req.URL.Redacted()
+	if json.Valid(body) {
+		cassette.Request.Body = body
+	}
+	cassette.Response.StatusCode = resp.StatusCode
+	cassette.Response.ContentType = resp.Header.Get("Content-Type")
+
+	// The response is saved once it has been read, so streamed responses
+	// are still displayed as they arrive.
+	resp.Body = &recordingBody{
+		ReadCloser: resp.Body,
+		fileName:   filepath.Join(t.dir, cassetteKey(req, body)+".json"),
+		cassette:   cassette,
+		out:        t.out,
+	}
+	return resp, nil
+}
+
+type recordingBody struct {
+	io.ReadCloser
+	fileName string
+	cassette *Cassette
+	body     bytes.Buffer
+	out      io.Writer
+}
+
+func (b *recordingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.body.Write(p[:n])
+	return n, err
+}
+
+func (b *recordingBody) Close() error {
+	// Save whatever wasn't read yet too, as when a stream ends at [DONE].
+	io.Copy(&b.body, b.ReadCloser)
+	b.cassette.Response.Body = b.body.String()
+
+	if data, err := json.MarshalIndent(b.cassette, "", "  "); err != nil {
+		warnf(b.out, "Warning: failed to record %s: %v", b.fileName, err)
+	} else if err := os.WriteFile(b.fileName, data, 0644); err != nil {
+		warnf(b.out, "Warning: failed to record %s: %v", b.fileName, err)
+	}
+
+	return b.ReadCloser.Close()
+}
+
+// replayingTransport serves the interactions recorded in dir, without
+// sending any request.
+type replayingTransport struct {
+	dir string
+}
+
+func (t *replayingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	key := cassetteKey(req, body)
+	data, err := os.ReadFile(filepath.Join(t.dir, key+".json"))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no recorded response for this request (%s) in %s", key, t.dir)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read recorded response: %w", err)
+	}
+
+	var cassette Cassette
+	if err := json.Unmarshal(data, &cassette); err != nil {
+		return nil, fmt.Errorf("invalid recorded response %s: %w", key, err)
+	}
+
+	return &http.Response{
+		StatusCode: cassette.Response.StatusCode,
+		Header:     http.Header{"Content-Type": {cassette.Response.ContentType}},
+		Body:       io.NopCloser(strings.NewReader(cassette.Response.Body)),
+		Request:    req,
+	}, nil
+}
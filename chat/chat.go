@@ -0,0 +1,1298 @@
+package chat
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+)
+
+const (
+	defaultTemperature      = 0.0
+	minTemperature          = 0.0
+	maxTemperature          = 2.0
+	defaultInputFile        = "messages.json"
+	defaultLogsBaseDir      = "logs"
+	defaultInputBaseDir     = "input"
+	defaultPromptsBaseDir   = "prompts"
+	logTimestampFormat      = "20060102T150405Z"
+	defaultMaxRetries       = 2
+	retryBaseDelay          = 500 * time.Millisecond
+	maxRetryDoublings       = 6 // caps the retry delay at 32s
+	defaultRetryJitter      = 1.0
+	defaultMaxInputBytes    = 10 << 20
+	defaultWarnPayloadBytes = 1 << 20
+	defaultUserPrefix       = ">> "
+	defaultAssistantPrefix  = "<< "
+	requestIDHeader         = "X-Request-Id"
+	defaultPromptDelimiter  = `\n\n`
+	// minThinkingBudget is the smallest thinking budget Anthropic accepts.
+	minThinkingBudget = 1024
+)
+
+type MsgRole string
+
+const (
+	USER      MsgRole = "user"
+	ASSISTANT MsgRole = "assistant"
+	SYSTEM    MsgRole = "system"
+)
+
+// roleAliases maps the lowercased roles used by other tools to the canonical
+// ones, so their message files and logs can be loaded as they are.
+var roleAliases = map[string]MsgRole{
+	"user":      USER,
+	"human":     USER,
+	"assistant": ASSISTANT,
+	"ai":        ASSISTANT,
+	"bot":       ASSISTANT,
+	"model":     ASSISTANT,
+	"system":    SYSTEM,
+}
+
+func normalizeRole(role MsgRole) (MsgRole, error) {
+	normalized, ok := roleAliases[strings.ToLower(strings.TrimSpace(string(role)))]
+	if !ok {
+		return "", fmt.Errorf("unknown role \"%s\"", role)
+	}
+	return normalized, nil
+}
+
+type MessageIn struct {
+	Role    MsgRole  `json:"role"`
+	Content string   `json:"content"`
+	File    string   `json:"file"`
+	Files   []string `json:"files"`
+	Cache   bool     `json:"cache"`
+}
+
+type Message struct {
+	Role    MsgRole `json:"role" yaml:"role"`
+	Content string  `json:"content" yaml:"content"`
+	// Cache marks the message as a prefix the provider may cache, which is
+	// sent as a cache_control annotation on its content.
+	Cache bool `json:"cache,omitempty" yaml:"cache,omitempty"`
+}
+
+// CacheControl is the prompt caching hint of Anthropic and the compatible
+// providers that support it.
+type CacheControl struct {
+	Type string `json:"type"`
+}
+
+type ContentPart struct {
+	Type         string        `json:"type"`
+	Text         string        `json:"text"`
+	CacheControl *CacheControl `json:"cache_control,omitempty"`
+}
+
+// UnmarshalJSON reads the content of a message either as plain text or, as
+// sent for messages marked with Cache, as text content parts.
+func (m *Message) UnmarshalJSON(data []byte) error {
+	type message Message
+	var raw struct {
+		message
+		Content json.RawMessage `json:"content"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*m = Message(raw.message)
+
+	if len(raw.Content) == 0 || raw.Content[0] != '[' {
+		if len(raw.Content) == 0 || string(raw.Content) == "null" {
+			return nil
+		}
+		return json.Unmarshal(raw.Content, &m.Content)
+	}
+
+	var parts []ContentPart
+	if err := json.Unmarshal(raw.Content, &parts); err != nil {
+		return err
+	}
+	var texts []string
+	for _, part := range parts {
+		if part.Type == "text" {
+			texts = append(texts, part.Text)
+		}
+		m.Cache = m.Cache || part.CacheControl != nil
+	}
+	m.Content = strings.Join(texts, "\n")
+	return nil
+}
+
+// cachedMessage is how a message marked with Cache is sent, as content parts
+// that carry the cache_control annotation.
+type cachedMessage struct {
+	Role    MsgRole       `json:"role"`
+	Content []ContentPart `json:"content"`
+}
+
+// ThinkingConfig enables extended thinking with a budget of tokens, for
+// providers with Anthropic-style reasoning controls.
+type ThinkingConfig struct {
+	Type         string `json:"type"`
+	BudgetTokens int    `json:"budget_tokens"`
+}
+
+type RequestPayload struct {
+	Model         string         `json:"model"`
+	Messages      []Message      `json:"messages"`
+	Temperature   float32        `json:"temperature"`
+	N             int            `json:"n,omitempty"`
+	Stream        bool           `json:"stream,omitempty"`
+	StreamOptions *StreamOptions `json:"stream_options,omitempty"`
+	// User identifies the end user on whose behalf the request is sent,
+	// which some providers use for abuse monitoring.
+	User      string `json:"user,omitempty"`
+	Seed      *int   `json:"seed,omitempty"`
+	MaxTokens int    `json:"max_tokens,omitempty"`
+	// MaxCompletionTokens replaces MaxTokens for the models that reject it.
+	MaxCompletionTokens int `json:"max_completion_tokens,omitempty"`
+	// ReasoningEffort and Thinking control how much reasoning models
+	// think, in the fields of OpenAI-style and Anthropic-style APIs.
+	ReasoningEffort string          `json:"reasoning_effort,omitempty"`
+	Thinking        *ThinkingConfig `json:"thinking,omitempty"`
+	// OmitTemperature leaves Temperature out of the request.
+	OmitTemperature bool `json:"-"`
+}
+
+type ResponseChoice struct {
+	Message      Message `json:"message"`
+	FinishReason string  `json:"finish_reason"`
+}
+
+// finishReasonLength is the finish reason of a response cut off at the
+// maximum number of tokens.
+const finishReasonLength = "length"
+
+// emptyResponseError describes a choice without any content, which some
+// providers return when the model only requested tool calls.
+func emptyResponseError(choice ResponseChoice) error {
+	switch choice.FinishReason {
+	case "tool_calls":
+		return fmt.Errorf("empty response: the model requested a tool call, which is not supported")
+	case finishReasonCancelled:
+		return fmt.Errorf("empty response: cancelled before any content was received")
+	}
+	return fmt.Errorf("empty response")
+}
+
+type ResponseBody struct {
+	Choices []ResponseChoice `json:"choices"`
+	Usage   *Usage           `json:"usage"`
+	// tokensPerSecond is the generation speed of a streamed response, or 0
+	// when it couldn't be measured.
+	tokensPerSecond float64
+	// requestIDs are the IDs of the requests sent with --trace that the
+	// response was received from.
+	requestIDs []string
+}
+
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
+
+type LogMetadata struct {
+	Model       string        `json:"model"`
+	Temperature float64       `json:"temperature"`
+	Tags        []string      `json:"tags,omitempty"`
+	Notes       []LogNote     `json:"notes,omitempty"`
+	Requests    []LogRequests `json:"requests,omitempty"`
+	Config      *SavedConfig  `json:"config,omitempty"`
+}
+
+// SavedConfig is the configuration written to the metadata of conversation
+// logs, to reproduce a run with --from-config. It only lists the settings that
+// are safe to write to disk and to restore: neither credentials, nor the hook
+// commands, nor the messages, and the URL without the credentials it can
+// embed. The fields are named like those of Config, which older logs saved
+// whole.
+type SavedConfig struct {
+	URL                 string
+	Choices             int
+	AutoRerank          bool
+	Stream              bool
+	Prefill             bool
+	MaxRetries          int
+	Tokenizer           string
+	PromptDelimiter     string
+	Seed                *int
+	LogNaming           string
+	MaxTokens           int
+	MaxCompletionTokens int
+	ReasoningEffort     string
+	ThinkingBudget      int
+}
+
+// secretQueryParams are the words that mark the query parameters of a URL
+// holding credentials, such as key, api_key or access_token.
+var secretQueryParams = []string{"key", "token", "secret", "password", "sig", "auth"}
+
+// redactedURL returns rawURL without its credentials: the user info, the
+// query parameters named after secrets and the fragment. A URL that doesn't
+// parse is left out entirely.
+func redactedURL(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	parsed.User = nil
+	parsed.Fragment = ""
+
+	query := parsed.Query()
+	for name := range query {
+		lower := strings.ToLower(name)
+		if slices.ContainsFunc(secretQueryParams, func(secret string) bool { return strings.Contains(lower, secret) }) {
+			query.Del(name)
+		}
+	}
+	parsed.RawQuery = query.Encode()
+	return parsed.String()
+}
+
+// savedConfig returns the settings of cfg written to the metadata of
+// conversation logs.
+func savedConfig(cfg *Config) *SavedConfig {
+	return &SavedConfig{
+		URL:                 redactedURL(cfg.URL),
+		Choices:             cfg.Choices,
+		AutoRerank:          cfg.AutoRerank,
+		Stream:              cfg.Stream,
+		Prefill:             cfg.Prefill,
+		MaxRetries:          cfg.MaxRetries,
+		Tokenizer:           cfg.Tokenizer,
+		PromptDelimiter:     cfg.PromptDelimiter,
+		Seed:                cfg.Seed,
+		LogNaming:           cfg.LogNaming,
+		MaxTokens:           cfg.MaxTokens,
+		MaxCompletionTokens: cfg.MaxCompletionTokens,
+		ReasoningEffort:     cfg.ReasoningEffort,
+		ThinkingBudget:      cfg.ThinkingBudget,
+	}
+}
+
+// readLogMetadata reads the metadata saved next to a conversation log, given
+// the path of either the log or the metadata file itself.
+func readLogMetadata(fileName string) (*LogMetadata, error) {
+	metadataFile := fileName
+	for _, suffix := range []string{logFileSuffix(jsonLogFormat), logFileSuffix(yamlLogFormat), ".meta.json"} {
+		metadataFile = strings.TrimSuffix(metadataFile, suffix)
+	}
+	metadataFile += ".meta.json"
+
+	fileContent, err := os.ReadFile(metadataFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read conversation metadata file: %w", err)
+	}
+
+	var metadata LogMetadata
+	if err := json.Unmarshal(fileContent, &metadata); err != nil {
+		return nil, fmt.Errorf("invalid conversation metadata file %s: %w", metadataFile, err)
+	}
+
+	return &metadata, nil
+}
+
+const (
+	// How conversation logs are named, set with --log-naming.
+	timestampLogNaming = "timestamp"
+	hashLogNaming      = "hash"
+)
+
+// logName returns the name of a conversation log, without its suffix. Logs
+// are named after the time they are saved, or, with the hash naming, after
+// the seed and a hash of the model, seed and first user prompt, so that the
+// runs of an experiment can be told apart and re-runs are easy to find.
+func logName(messages []Message, metadata LogMetadata) string {
+	if metadata.Config == nil || metadata.Config.LogNaming != hashLogNaming {
+		return time.Now().UTC().Format(logTimestampFormat)
+	}
+
+	seed := "noseed"
+	if metadata.Config.Seed != nil {
+		seed = fmt.Sprintf("seed%d", *metadata.Config.Seed)
+	}
+	firstPrompt := ""
+	for _, msg := range messages {
+		if msg.Role == USER {
+			firstPrompt = msg.Content
+			break
+		}
+	}
+
+	hash := sha256.Sum256([]byte(metadata.Model + "\x00" + seed + "\x00" + firstPrompt))
+	return seed + "-" + hex.EncodeToString(hash[:4])
+}
+
+func saveConversationLog(out io.Writer, messages []Message, metadata LogMetadata, logsDir string, format string) error {
+	fileContent, err := marshalConversation(messages, format)
+	if err != nil {
+		return fmt.Errorf("failed to JSON parse conversation content: %w", err)
+	}
+
+	metadataContent, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to JSON parse conversation metadata: %w", err)
+	}
+
+	logDir := path.Join(logsDir, sanitizeModelName(metadata.Model))
+	if err := os.MkdirAll(logDir, 0755); err != nil {
+		return fmt.Errorf("failed to create log directory: %w", err)
+	}
+
+	name := logName(messages, metadata)
+	baseName := path.Join(logDir, name)
+	suffix := logFileSuffix(format)
+	for n := 1; fileExists(baseName + suffix); n++ {
+		baseName = path.Join(logDir, fmt.Sprintf("%s-%d", name, n))
+	}
+
+	fileName := baseName + suffix
+	if err := os.WriteFile(fileName, fileContent, 0644); err != nil {
+		return fmt.Errorf("failed to save conversation log file: %w", err)
+	}
+
+	if err := os.WriteFile(baseName+".meta.json", metadataContent, 0644); err != nil {
+		return fmt.Errorf("failed to save conversation metadata file: %w", err)
+	}
+
+	fmt.Fprintf(out, "Conversation saved to %s\n", fileName)
+	return nil
+}
+
+func readConversationLog(fileName string) ([]Message, error) {
+	fileContent, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read conversation log file: %w", err)
+	}
+
+	var messages []Message
+	if err := unmarshalConversation(fileName, fileContent, &messages); err != nil {
+		return nil, fmt.Errorf("invalid conversation log file %s: %w", fileName, err)
+	}
+
+	for i := range messages {
+		role, err := normalizeRole(messages[i].Role)
+		if err != nil {
+			return nil, fmt.Errorf("invalid conversation log file %s: message %d: %w", fileName, i, err)
+		}
+		messages[i].Role = role
+	}
+
+	return messages, nil
+}
+
+// sanitizeModelName turns a model name into a single, portable path segment
+// by replacing path separators and characters that some filesystems reject,
+// e.g. "meta-llama/Llama-3:8b" becomes "meta-llama_Llama-3_8b".
+func sanitizeModelName(model string) string {
+	sanitized := strings.Map(func(r rune) rune {
+		switch {
+		case strings.ContainsRune(`/\:*?"<>|`, r), unicode.IsSpace(r), unicode.IsControl(r):
+			return '_'
+		}
+		return r
+	}, model)
+
+	if strings.Trim(sanitized, ".") == "" {
+		return strings.Repeat("_", len(sanitized))
+	}
+	return sanitized
+}
+
+func saveSessionLog(out io.Writer, messages []Message, cfg *Config, annotations *logAnnotations) {
+	metadata := LogMetadata{
+		Model:       cfg.Model,
+		Temperature: cfg.Temperature,
+		Tags:        cfg.Tags,
+		Notes:       annotations.notes,
+		Requests:    loggedRequests(annotations.requests, messages),
+		Config:      savedConfig(cfg),
+	}
+	if err := saveConversationLog(out, messages, metadata, cfg.LogsDir, cfg.ExportFormat); err != nil {
+		warnf(out, "Error saving conversation log: %v", err)
+	}
+}
+
+func fileExists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
+// errInputClosed is returned when there is no more user input to read, e.g.
+// when input is piped from a file that has been fully consumed.
+var errInputClosed = errors.New("input closed")
+
+func readUserInput(out io.Writer, reader *bufio.Reader, prefix string) (string, error) {
+	fmt.Fprint(out, prefix)
+	userInput, err := reader.ReadString('\n')
+	if err == io.EOF && userInput != "" {
+		fmt.Fprintln(out)
+	} else if err == io.EOF {
+		fmt.Fprintln(out)
+		return "", errInputClosed
+	} else if err != nil {
+		return "", fmt.Errorf("failed to read user input: %w", err)
+	}
+
+	return strings.TrimRight(userInput, "\r\n"), nil
+}
+
+const pasteSentinel = "/end"
+
+func readPastedText(out io.Writer, reader *bufio.Reader) (string, error) {
+	fmt.Fprintf(out, "Paste mode: finish with a line containing only %s or with Ctrl+D (Ctrl+Z and Enter on Windows)\n", pasteSentinel)
+
+	var pasted strings.Builder
+	for {
+		line, err := reader.ReadString('\n')
+		if strings.TrimRight(line, "\r\n") == pasteSentinel {
+			break
+		}
+		pasted.WriteString(line)
+
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to read pasted text: %w", err)
+		}
+	}
+
+	return strings.TrimRight(pasted.String(), "\r\n"), nil
+}
+
+func confirm(out io.Writer, reader *bufio.Reader, question string) (bool, error) {
+	fmt.Fprintf(out, "%s [y/N] ", question)
+	answer, err := reader.ReadString('\n')
+	if err == io.EOF && answer == "" {
+		fmt.Fprintln(out)
+		return false, errInputClosed
+	} else if err != nil && err != io.EOF {
+		return false, fmt.Errorf("failed to read confirmation: %w", err)
+	}
+
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes", nil
+}
+
+// defaultStopCommands end the session when Config.StopCommands is empty.
+var defaultStopCommands = []string{"/quit"}
+
+// matchStopCommand reports whether input ends the session, and if so whether
+// the conversation is saved first. Each stop command saves it, while the same
+// command followed by "!" exits without saving. Slash commands are matched
+// against the command name given by parseCommand, so that "/quit now" stops
+// like "/quit", while bare words like "exit" must be the whole input.
+// Matching ignores case and the surrounding whitespace.
+func matchStopCommand(stopCommands []string, input string) (stop bool, save bool) {
+	if len(stopCommands) == 0 {
+		stopCommands = defaultStopCommands
+	}
+
+	name, _ := parseCommand(input)
+	for _, command := range stopCommands {
+		target := strings.TrimSpace(input)
+		if strings.HasPrefix(command, "/") {
+			target = name
+		}
+		if strings.EqualFold(target, command) {
+			return true, true
+		}
+		if strings.EqualFold(target, command+"!") {
+			return true, false
+		}
+	}
+	return false, false
+}
+
+// parseCommand splits a chat command into its name and arguments. Input that
+// does not start with a slash is not a command and yields an empty name.
+func parseCommand(input string) (string, string) {
+	trimmed := strings.TrimSpace(input)
+	if !strings.HasPrefix(trimmed, "/") {
+		return "", ""
+	}
+
+	name, args, _ := strings.Cut(trimmed, " ")
+	return name, strings.TrimSpace(args)
+}
+
+type inputAction int
+
+const (
+	actionMessage inputAction = iota
+	actionRetry
+	actionQuit
+	// actionCompare sends the last prompt to another model, given as the
+	// input, without changing the conversation.
+	actionCompare
+	// actionLoad replaces the conversation with the log given as the input.
+	actionLoad
+	// actionResend sends the last user message again, replacing its reply,
+	// with the parameter overrides given as the input.
+	actionResend
+)
+
+// promptUser reads user input until a message or an action is entered,
+// handling the chat commands along the way.
+func promptUser(out io.Writer, reader *bufio.Reader, terminal bool, messages []Message, savedMsgsCount int, canRetry bool, stats *sessionStats, annotations *logAnnotations, cfg *Config) (string, inputAction, error) {
+	for {
+		userInput, err := readUserInput(out, reader, cfg.UserPrefix)
+		if err != nil {
+			return "", actionQuit, err
+		}
+
+		if stop, save := matchStopCommand(cfg.StopCommands, userInput); stop && save {
+			saveSessionLog(out, messages, cfg, annotations)
+			return "", actionQuit, nil
+		} else if stop {
+			if cfg.ConfirmQuit && len(messages) > savedMsgsCount {
+				ok, err := confirm(out, reader, "There are unsaved messages. Quit without saving?")
+				if err != nil {
+					return "", actionQuit, err
+				}
+				if !ok {
+					continue
+				}
+			}
+			return "", actionQuit, nil
+		}
+
+		command, args := parseCommand(userInput)
+		switch command {
+		case "/retry":
+			if !canRetry {
+				fmt.Fprintln(out, "!! Nothing to retry: the last request did not fail")
+				continue
+			}
+			return "", actionRetry, nil
+		case "/stats":
+			stats.print(out, cfg)
+			continue
+		case "/compare":
+			if args == "" {
+				fmt.Fprintln(out, "!! Usage: /compare <model>")
+				continue
+			}
+			return args, actionCompare, nil
+		case "/resend":
+			if _, ok := dropLastReply(messages); !ok {
+				fmt.Fprintln(out, "!! Nothing to resend: there is no user message")
+				continue
+			}
+			return args, actionResend, nil
+		case "/export":
+			format, fileName, _ := strings.Cut(args, " ")
+			if !isExportFormat(format) {
+				fmt.Fprintf(out, "!! Usage: /export %s [file]\n", strings.Join(exportFormats, "|"))
+				continue
+			}
+			if err := exportConversation(out, messages, cfg, format, strings.TrimSpace(fileName)); err != nil {
+				fmt.Fprintf(out, "!! %v\n", err)
+			}
+			continue
+		case "/export-range":
+			if len(strings.Fields(args)) != 3 {
+				fmt.Fprintln(out, "!! Usage: /export-range <start> <end> <file>")
+				continue
+			}
+			if err := exportRange(out, messages, cfg, args); err != nil {
+				fmt.Fprintf(out, "!! %v\n", err)
+			}
+			continue
+		case "/note":
+			if args == "" {
+				printNotes(out, annotations.notes)
+				continue
+			}
+			addNote(annotations, messages, args)
+			fmt.Fprintln(out, "Note added, it won't be sent to the model")
+			continue
+		case "/tag":
+			cfg.Tags = addTags(cfg.Tags, strings.Split(args, ",")...)
+			if len(cfg.Tags) == 0 {
+				fmt.Fprintln(out, "No tags. Use /tag <tag>[,<tag>...] to add some")
+			} else {
+				fmt.Fprintf(out, "Tags: %s\n", strings.Join(cfg.Tags, ", "))
+			}
+			continue
+		case "/load":
+			fileName := args
+			if fileName == "" {
+				fileName, err = pickConversationLog(out, reader, cfg.LogsDir, cfg.TagFilter)
+				if err != nil && !errors.Is(err, errInputClosed) {
+					fmt.Fprintf(out, "!! %v\n", err)
+					continue
+				} else if err != nil {
+					return "", actionQuit, err
+				}
+				if fileName == "" {
+					continue
+				}
+			}
+
+			if len(messages) > 0 {
+				ok, err := confirm(out, reader, fmt.Sprintf("Replace the current %d messages with the loaded conversation?", len(messages)))
+				if err != nil {
+					return "", actionQuit, err
+				}
+				if !ok {
+					continue
+				}
+			}
+			return fileName, actionLoad, nil
+		case "/tokens":
+			count, exact := countMessagesTokens(messages, cfg.Tokenizer)
+			if _, err := loadTokenizer(cfg.Tokenizer); err != nil {
+				warnf(out, "Warning: %v", err)
+			}
+			if exact {
+				fmt.Fprintf(out, "Context: %d tokens in %d messages (%s)\n", count, len(messages), cfg.Tokenizer)
+			} else {
+				fmt.Fprintf(out, "Context: ~%d tokens in %d messages (estimated)\n", count, len(messages))
+			}
+			continue
+		case "/paste":
+			pasted, err := readPastedText(out, reader)
+			if err != nil {
+				return "", actionQuit, err
+			}
+			if pasted == "" {
+				fmt.Fprintln(out, "!! Nothing was pasted")
+				continue
+			}
+
+			fmt.Fprintf(out, "Captured %d bytes (%d lines)\n", len(pasted), strings.Count(pasted, "\n")+1)
+			userInput = pasted
+		case "/pasteclip":
+			clipboard, err := readClipboard()
+			if err != nil {
+				fmt.Fprintf(out, "!! %v\n", err)
+				continue
+			}
+			if strings.TrimSpace(clipboard) == "" {
+				fmt.Fprintln(out, "!! The clipboard is empty")
+				continue
+			}
+
+			fmt.Fprintf(out, "Captured %d bytes (%d lines) from the clipboard\n", len(clipboard), strings.Count(clipboard, "\n")+1)
+			userInput = clipboard
+		}
+
+		userInput, ok := preProcess(out, cfg, userInput)
+		if !ok {
+			continue
+		}
+
+		if last := len(messages) - 1; last >= 0 && messages[last].Role == USER && messages[last].Content == userInput {
+			// Without a terminal, the next line of the input would be
+			// taken as the answer, so the message is sent as scripted.
+			if !terminal {
+				warnf(out, "Warning: this is identical to the previous user message, sending it again")
+			} else if ok, err := confirm(out, reader, "!! This is identical to the previous user message. Send it again?"); err != nil {
+				return "", actionQuit, err
+			} else if !ok {
+				continue
+			}
+		}
+
+		prompt := append(messages[:len(messages):len(messages)], Message{Role: USER, Content: userInput})
+		if ok, err := confirmPromptSize(out, reader, terminal, prompt, cfg); err != nil {
+			return "", actionQuit, err
+		} else if !ok {
+			continue
+		}
+
+		return userInput, actionMessage, nil
+	}
+}
+
+// confirmPromptSize asks whether to send the prompt of messages when it is
+// estimated to be over the --confirm-over limit. Without a terminal to ask
+// on, the next line of the input would be taken as the answer, so the prompt
+// isn't sent.
+func confirmPromptSize(out io.Writer, reader *bufio.Reader, terminal bool, messages []Message, cfg *Config) (bool, error) {
+	if cfg.ConfirmOver == 0 {
+		return true, nil
+	}
+	count, _ := countMessagesTokens(windowMessages(messages, cfg.Window), cfg.Tokenizer)
+	if count <= cfg.ConfirmOver {
+		return true, nil
+	}
+
+	if !terminal {
+		fmt.Fprintf(out, "!! The prompt is about %d tokens, over the limit of %d, and there's no terminal to confirm sending it\n", count, cfg.ConfirmOver)
+		return false, nil
+	}
+	return confirm(out, reader, fmt.Sprintf("!! The prompt is about %d tokens, over the limit of %d. Send it anyway?", count, cfg.ConfirmOver))
+}
+
+// countRoles returns the number of system, user and assistant messages.
+func countRoles(messages []Message) (int, int, int) {
+	systemMsgsCount := 0
+	userMsgsCount := 0
+	assistantMsgsCount := 0
+
+	for _, msg := range messages {
+		switch msg.Role {
+		case USER:
+			userMsgsCount++
+		case ASSISTANT:
+			assistantMsgsCount++
+		case SYSTEM:
+			systemMsgsCount++
+		}
+	}
+
+	return systemMsgsCount, userMsgsCount, assistantMsgsCount
+}
+
+func displayInitScreen(out io.Writer, messages []Message, sourceFiles [][]string, model string, temperature float32) {
+	systemMsgsCount, userMsgsCount, assistantMsgsCount := countRoles(messages)
+
+	promptFilesSection := ""
+	for i, msg := range messages {
+		if msg.Role != SYSTEM {
+			continue
+		}
+		for _, name := range sourceFiles[i] {
+			promptFilesSection += fmt.Sprintf("|   %-47s|\n", fitBannerWidth(name, 47))
+		}
+	}
+	if promptFilesSection != "" {
+		promptFilesSection = "|--------------------------------------------------|\n" +
+			"| System Prompt Files:                             |\n" +
+			"|                                                  |\n" +
+			promptFilesSection +
+			"|                                                  |\n"
+	}
+
+	fmt.Fprintf(out, `
++--------------------------------------------------+
+|                                                  |
+|      You are now chatting with the model:        |
+|                                                  |
++--------------------------------------------------+
+
+> %s
+
++--------------------------------------------------+
+| Temperature: %.2f                                |
+|--------------------------------------------------|
+| Context Messages Count:                          |
+|                                                  |
+|   System:    %3d                                 |
+|   User:      %3d                                 |
+|   Assistant: %3d                                 |
+|                                                  |
+%s|--------------------------------------------------|
+| Commands:                                        |
+|                                                  |
+|   >> /quit         to save conversation and exit |
+|   >> /quit!        to exit without saving        |
+|   >> /retry        to resend a failed request    |
+|   >> /paste        to send a large block of text |
+|   >> /pasteclip    to send the clipboard         |
+|   >> /tokens       to count the context tokens   |
+|   >> /stats        to show the session metrics   |
+|   >> /compare      to ask another model          |
+|   >> /resend       to resend with new parameters |
+|   >> /export       to save a copy and keep going |
+|   >> /export-range to save some of the messages  |
+|   >> /load         to resume a saved chat        |
+|   >> /note         to add a note to the log      |
+|   >> /tag          to tag the conversation log   |
+|                                                  |
++--------------------------------------------------+
+
+`, model, temperature, systemMsgsCount, userMsgsCount, assistantMsgsCount, promptFilesSection)
+}
+
+// fitBannerWidth shortens text to width characters, keeping its end, which
+// is the most telling part of a file path.
+func fitBannerWidth(text string, width int) string {
+	runes := []rune(text)
+	if len(runes) <= width {
+		return text
+	}
+	return "..." + string(runes[len(runes)-width+3:])
+}
+
+// maxCompletionTokensModels are the prefixes of the models that reject
+// max_tokens in favor of max_completion_tokens, such as OpenAI's reasoning
+// models.
+var maxCompletionTokensModels = []string{"o1", "o3", "o4", "gpt-5"}
+
+// responseTokenLimit returns the response token limit to send for model,
+// reporting whether it goes in max_completion_tokens rather than max_tokens:
+// when set with --max-completion-tokens, or when model requires it.
+func responseTokenLimit(cfg *Config, model string) (int, bool) {
+	if cfg.MaxCompletionTokens > 0 {
+		return cfg.MaxCompletionTokens, true
+	}
+
+	// Gateways often prefix models with their vendor, e.g. "openai/o3", and
+	// the versions follow a dash or a dot, e.g. "o3-mini" or "gpt-5.1".
+	name := strings.ToLower(model[strings.LastIndex(model, "/")+1:])
+	for _, prefix := range maxCompletionTokensModels {
+		if name == prefix || strings.HasPrefix(name, prefix+"-") || strings.HasPrefix(name, prefix+".") {
+			return cfg.MaxTokens, true
+		}
+	}
+	return cfg.MaxTokens, false
+}
+
+func newChatRequest(out io.Writer, cfg *Config, payload RequestPayload) (*http.Request, error) {
+	payload.User = cfg.EndUser
+	payload.Seed = cfg.Seed
+	if maxTokens, ok := responseTokenLimit(cfg, payload.Model); ok {
+		payload.MaxCompletionTokens = maxTokens
+	} else {
+		payload.MaxTokens = maxTokens
+	}
+	payload.ReasoningEffort = cfg.ReasoningEffort
+	if cfg.ThinkingBudget > 0 {
+		payload.Thinking = &ThinkingConfig{Type: "enabled", BudgetTokens: cfg.ThinkingBudget}
+	}
+	payload.OmitTemperature = cfg.OmitTemperature
+	payloadBytes, err := marshalPayload(payload)
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling payload: %w", err)
+	}
+	payloadBytes, err = remapFields(payloadBytes, requestFields(cfg))
+	if err != nil {
+		return nil, fmt.Errorf("error renaming payload fields: %w", err)
+	}
+	if cfg.WarnPayloadBytes > 0 && int64(len(payloadBytes)) > cfg.WarnPayloadBytes {
+		warnf(out, "Warning: the request payload is %d bytes, over the --warn-payload-bytes limit of %d. Check the conversation for an accidentally large message", len(payloadBytes), cfg.WarnPayloadBytes)
+	}
+
+	req, err := http.NewRequest("POST", cfg.URL, bytes.NewBuffer(payloadBytes))
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	setAuthorization(req, cfg)
+
+	return req, nil
+}
+
+// marshalPayload encodes payload as the request body. Messages are sent with
+// plain text content, except those marked with Cache.
+func marshalPayload(payload RequestPayload) ([]byte, error) {
+	if payload.OmitTemperature {
+		return marshalWithoutTemperature(payload)
+	}
+
+	cached := false
+	for _, msg := range payload.Messages {
+		cached = cached || msg.Cache
+	}
+	if !cached {
+		return json.Marshal(payload)
+	}
+
+	messages := make([]any, len(payload.Messages))
+	for i, msg := range payload.Messages {
+		if !msg.Cache {
+			messages[i] = msg
+			continue
+		}
+		messages[i] = cachedMessage{
+			Role: msg.Role,
+			Content: []ContentPart{{
+				Type:         "text",
+				Text:         msg.Content,
+				CacheControl: &CacheControl{Type: "ephemeral"},
+			}},
+		}
+	}
+
+	// The Messages field of the outer struct takes precedence over the one
+	// of the embedded payload.
+	return json.Marshal(struct {
+		RequestPayload
+		Messages []any `json:"messages"`
+	}{payload, messages})
+}
+
+func marshalWithoutTemperature(payload RequestPayload) ([]byte, error) {
+	payload.OmitTemperature = false
+	data, err := marshalPayload(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	delete(fields, "temperature")
+	return json.Marshal(fields)
+}
+
+// setAuthorization authenticates req with basic auth, when configured, or
+// with the API key as a bearer token otherwise.
+func setAuthorization(req *http.Request, cfg *Config) {
+	if cfg.BasicAuthUser != "" {
+		req.SetBasicAuth(cfg.BasicAuthUser, cfg.BasicAuthPassword)
+		return
+	}
+	req.Header.Set("Authorization", "Bearer "+cfg.APIKey)
+}
+
+// newHTTPClient returns the client shared by every request of the session.
+func newHTTPClient(out io.Writer, cfg *Config) (*http.Client, error) {
+	tlsConfig, err := newTLSConfig(out, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	baseTransport := http.DefaultTransport.(*http.Transport).Clone()
+	baseTransport.TLSClientConfig = tlsConfig
+
+	var transport http.RoundTripper = baseTransport
+	if cfg.Provider == mockProvider {
+		transport = &mockTransport{response: cfg.MockResponse}
+	}
+	if cfg.ReplayHTTPDir != "" {
+		transport = &replayingTransport{dir: cfg.ReplayHTTPDir}
+	} else if cfg.RecordDir != "" {
+		if err := os.MkdirAll(cfg.RecordDir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create recording directory: %w", err)
+		}
+		transport = &recordingTransport{dir: cfg.RecordDir, next: transport, out: out}
+	}
+	if cfg.RequestsPerMinute > 0 {
+		transport = &rateLimitedTransport{limiter: newRateLimiter(cfg.RequestsPerMinute), next: transport, out: out}
+	}
+
+	return &http.Client{Transport: transport}, nil
+}
+
+// newTLSConfig returns the TLS settings for the client, trusting the CA in
+// cfg.CACert in addition to the system ones, or skipping verification
+// entirely with cfg.Insecure. With cfg.ClientCert, the key pair is presented
+// to servers that require mutual TLS.
+func newTLSConfig(out io.Writer, cfg *Config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+
+	if cfg.CACert != "" {
+		certData, err := os.ReadFile(cfg.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(certData) {
+			return nil, fmt.Errorf("no PEM certificates found in %s", cfg.CACert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.ClientCert != "" {
+		certificate, err := tls.LoadX509KeyPair(cfg.ClientCert, cfg.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	if cfg.Insecure {
+		warnf(out, "Warning: TLS certificate verification is disabled (--insecure). The connection can be intercepted, exposing your API key and conversations")
+		tlsConfig.InsecureSkipVerify = true
+	}
+
+	return tlsConfig, nil
+}
+
+func doChatRequest(out io.Writer, client *http.Client, cfg *Config, payload RequestPayload) (*http.Response, error) {
+	req, err := newChatRequest(out, cfg, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	requestID := ""
+	if cfg.Trace {
+		requestID = uuid.NewString()
+		req.Header.Set(requestIDHeader, requestID)
+		warnf(out, "Sending request %s (%d bytes)", requestID, req.ContentLength)
+	}
+
+	emitEvent(out, "request_sent", map[string]any{
+		"model":      payload.Model,
+		"messages":   len(payload.Messages),
+		"stream":     payload.Stream,
+		"request_id": requestID,
+	})
+
+	waiting := showWaiting(out, cfg)
+	resp, err := client.Do(req)
+	waiting.clear()
+	if err != nil {
+		return nil, traceError(req, fmt.Errorf("error sending request: %w", err))
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		fmt.Fprintf(out, "!! API Error: %s\n", string(bodyBytes))
+		return nil, traceError(req, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(bodyBytes)))
+	}
+
+	return resp, nil
+}
+
+// tracedRequestIDs returns the ID of req in a list, when tracing is enabled,
+// or nil otherwise.
+func tracedRequestIDs(req *http.Request) []string {
+	if id := req.Header.Get(requestIDHeader); id != "" {
+		return []string{id}
+	}
+	return nil
+}
+
+// traceError adds the ID of the request to err, when tracing is enabled, so
+// users can report it to their provider.
+func traceError(req *http.Request, err error) error {
+	if id := req.Header.Get(requestIDHeader); id != "" {
+		return fmt.Errorf("%w (request ID: %s)", err, id)
+	}
+	return err
+}
+
+// sendChatRequest sends a chat request and decodes its response into
+// responseBody. Responses that are not valid JSON, which some providers
+// occasionally return truncated, are retried up to cfg.MaxRetries times.
+// When retries are exhausted the raw body is returned along with the error.
+func sendChatRequest(out io.Writer, client *http.Client, cfg *Config, payload RequestPayload, responseBody *ResponseBody) ([]byte, error) {
+	for retry := 0; ; retry++ {
+		resp, err := doChatRequest(out, client, cfg, payload)
+		if err != nil {
+			return nil, err
+		}
+
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, traceError(resp.Request, fmt.Errorf("error reading response body: %w", err))
+		}
+
+		// A body that fails to decode can still fill some of the fields,
+		// so it is decoded apart, and neither it nor an earlier response
+		// is left in responseBody.
+		*responseBody = ResponseBody{}
+		var decoded ResponseBody
+		remapped, err := remapFields(body, responseFields(cfg))
+		if err == nil {
+			err = json.Unmarshal(remapped, &decoded)
+		}
+		if err == nil {
+			decoded.requestIDs = tracedRequestIDs(resp.Request)
+			*responseBody = decoded
+			return body, nil
+		}
+
+		if retry >= cfg.MaxRetries {
+			fmt.Fprintf(out, "Raw response: %s\n", string(body))
+			return body, traceError(resp.Request, fmt.Errorf("error unmarshalling response body: %w", err))
+		}
+
+		warnf(out, "Warning: malformed response body, retrying (%d/%d): %v", retry+1, cfg.MaxRetries, err)
+		time.Sleep(retryDelay(retry, cfg.RetryJitter))
+	}
+}
+
+// retryDelay returns how long to wait before the given retry: a delay that
+// doubles with each retry, of which the jitter fraction is randomized, so
+// that concurrent clients don't retry in lockstep. A jitter of 1 picks any
+// delay up to the full one, and 0 always waits the full delay. The delay
+// stops doubling after maxRetryDoublings retries.
+func retryDelay(retry int, jitter float64) time.Duration {
+	delay := retryBaseDelay << min(retry, maxRetryDoublings)
+	return delay - time.Duration(jitter*rand.Float64()*float64(delay))
+}
+
+// printResponseUsage prints the usage of a response, followed by its
+// generation speed for streamed responses. Without the usage reported by the
+// provider, the speed is estimated from the tokens received.
+func printResponseUsage(out io.Writer, responseBody ResponseBody) {
+	if responseBody.tokensPerSecond <= 0 {
+		printUsage(out, responseBody.Usage)
+		return
+	}
+
+	if usage := responseBody.Usage; usage != nil {
+		fmt.Fprintf(out, "\n[Input: %d tokens, Output: %d tokens, %.1f tokens/s]\n", usage.PromptTokens, usage.CompletionTokens, responseBody.tokensPerSecond)
+	} else {
+		fmt.Fprintf(out, "\n[Token usage unavailable, ~%.1f tokens/s]\n", responseBody.tokensPerSecond)
+	}
+}
+
+func printUsage(out io.Writer, usage *Usage) {
+	if usage == nil {
+		fmt.Fprintln(out, "\n[Token usage unavailable]")
+		return
+	}
+
+	fmt.Fprintf(out, "\n[Input: %d tokens, Output: %d tokens]\n",
+		usage.PromptTokens,
+		usage.CompletionTokens,
+	)
+}
+
+// readAllLimited reads r to the end, failing once more than maxBytes have
+// been read, so that a wrong file doesn't end up filling the context. A
+// maxBytes of 0 reads without limit.
+func readAllLimited(r io.Reader, maxBytes int64) ([]byte, error) {
+	if maxBytes <= 0 {
+		return io.ReadAll(r)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r, maxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, fmt.Errorf("file is larger than the --max-input-bytes limit of %d bytes", maxBytes)
+	}
+	return data, nil
+}
+
+func readInputFile(name string, maxBytes int64) ([]byte, error) {
+	inputFile, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open input file: %w", err)
+	}
+	defer inputFile.Close()
+
+	inputData, err := readAllLimited(inputFile, maxBytes)
+	if err != nil {
+		return nil, fmt.Errorf("error reading input file %s: %w", name, err)
+	}
+
+	return inputData, nil
+}
+
+func readPromptFile(promptsDir string, name string, maxBytes int64) (string, error) {
+	promptFile, err := os.Open(path.Join(promptsDir, name))
+	if err != nil {
+		return "", fmt.Errorf("failed to open message file: %w", err)
+	}
+	defer promptFile.Close()
+
+	promptData, err := readAllLimited(promptFile, maxBytes)
+	if err != nil {
+		return "", fmt.Errorf("error reading message file %s: %w", name, err)
+	}
+
+	return string(promptData), nil
+}
+
+// loadMessages builds the conversation from the input messages, loading the
+// content of messages that reference files. Along with the messages, it
+// returns the names of the files each message was loaded from.
+func loadMessages(out io.Writer, cfg *Config, messagesIn []MessageIn) ([]Message, [][]string, error) {
+	messages := []Message{}
+	sourceFiles := [][]string{}
+
+	for i, msg := range messagesIn {
+		role, err := normalizeRole(msg.Role)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid input message %d: %w", i, err)
+		}
+		msg.Role = role
+
+		cache := msg.Cache || (cfg.CacheSystem && msg.Role == SYSTEM)
+		messages = append(messages, Message{Role: msg.Role, Content: msg.Content, Cache: cache})
+		sourceFiles = append(sourceFiles, nil)
+
+		files := msg.Files
+		if msg.File != "" {
+			files = append([]string{msg.File}, msg.Files...)
+		}
+
+		if (msg.Role == SYSTEM || msg.Role == ASSISTANT) && len(files) > 0 {
+			fileData, err := readPromptFiles(cfg.PromptsDir, files, cfg.PromptDelimiter, cfg.MaxInputBytes)
+			if err != nil && cfg.NoSystemFileFatal {
+				warnf(out, "Warning: %v. Keeping the inline content instead", err)
+				continue
+			} else if err != nil {
+				return nil, nil, fmt.Errorf("failed to load %s message: %w", msg.Role, err)
+			}
+
+			messages[i].Content = fileData
+			sourceFiles[i] = files
+		}
+	}
+
+	return messages, sourceFiles, nil
+}
+
+// windowMessages returns the messages to send when only the last size
+// non-system messages are kept, with every system message in its original
+// position. A size of 0 keeps every message.
+func windowMessages(messages []Message, size int) []Message {
+	if size <= 0 {
+		return messages
+	}
+
+	kept := 0
+	start := len(messages)
+	for start > 0 && (kept < size || messages[start-1].Role == SYSTEM) {
+		start--
+		if messages[start].Role != SYSTEM {
+			kept++
+		}
+	}
+	if start == 0 {
+		return messages
+	}
+
+	window := []Message{}
+	for _, msg := range messages[:start] {
+		if msg.Role == SYSTEM {
+			window = append(window, msg)
+		}
+	}
+	return append(window, messages[start:]...)
+}
+
+// readPromptFiles reads each of the named files and joins their contents, in
+// order, with the delimiter.
+func readPromptFiles(promptsDir string, names []string, delimiter string, maxBytes int64) (string, error) {
+	fragments := make([]string, 0, len(names))
+	for _, name := range names {
+		fragment, err := readPromptFile(promptsDir, name, maxBytes)
+		if err != nil {
+			return "", err
+		}
+		fragments = append(fragments, fragment)
+	}
+
+	return strings.Join(fragments, delimiter), nil
+}
@@ -0,0 +1,291 @@
+package chat
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+func TestMatchStopCommand(t *testing.T) {
+	tests := []struct {
+		stopCommands []string
+		input        string
+		stop, save   bool
+	}{
+		{nil, "/quit", true, true},
+		{nil, "  /QUIT \n", true, true},
+		{nil, "/quit now", true, true},
+		{nil, "/quit!", true, false},
+		{nil, "/quit! please", true, false},
+		{nil, "/quitter", false, false},
+		{nil, "please /quit", false, false},
+		{[]string{"/quit", "exit"}, "exit", true, true},
+		{[]string{"/quit", "exit"}, "Exit!", true, false},
+		{[]string{"/quit", "exit"}, "exit the loop", false, false},
+		{[]string{"exit"}, "/quit", false, false},
+	}
+	for _, test := range tests {
+		stop, save := matchStopCommand(test.stopCommands, test.input)
+		if stop != test.stop || save != test.save {
+			t.Errorf("matchStopCommand(%q, %q) = %v, %v, want %v, %v", test.stopCommands, test.input, stop, save, test.stop, test.save)
+		}
+	}
+}
+
+func TestSaveConversationLogMarshalFailure(t *testing.T) {
+	logsDir := t.TempDir()
+	// NaN can't be encoded as JSON, so the metadata fails to marshal.
+	metadata := LogMetadata{Model: "gpt-4o", Temperature: math.NaN()}
+	messages := []Message{{Role: USER, Content: "Hello"}}
+
+	if err := saveConversationLog(io.Discard, messages, metadata, logsDir, jsonLogFormat); err == nil {
+		t.Fatal("saveConversationLog succeeded, want a marshal error")
+	}
+	entries, err := os.ReadDir(logsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("logs directory contains %d entries after a failed marshal, want none", len(entries))
+	}
+}
+
+func TestSanitizeModelName(t *testing.T) {
+	tests := []struct {
+		model, want string
+	}{
+		{"gpt-4o", "gpt-4o"},
+		{"meta-llama/Llama-3:8b", "meta-llama_Llama-3_8b"},
+		{`org\model`, "org_model"},
+		{"my model\tv2", "my_model_v2"},
+		{"bad\x00name\x1b", "bad_name_"},
+		{`a*b?c"d<e>f|g`, "a_b_c_d_e_f_g"},
+		{"..", "__"},
+		{"llama.cpp", "llama.cpp"},
+	}
+	for _, test := range tests {
+		if got := sanitizeModelName(test.model); got != test.want {
+			t.Errorf("sanitizeModelName(%q) = %q, want %q", test.model, got, test.want)
+		}
+	}
+}
+
+func TestReadUserInputCRLF(t *testing.T) {
+	reader := bufio.NewReader(strings.NewReader("hello\r\n\r\n/quit\r\nlast\r"))
+	for _, want := range []string{"hello", "", "/quit", "last"} {
+		got, err := readUserInput(io.Discard, reader, "")
+		if err != nil {
+			t.Fatalf("readUserInput() error = %v, want %q", err, want)
+		}
+		if got != want {
+			t.Errorf("readUserInput() = %q, want %q", got, want)
+		}
+	}
+	if _, err := readUserInput(io.Discard, reader, ""); !errors.Is(err, errInputClosed) {
+		t.Errorf("readUserInput() at the end of the input: error = %v, want %v", err, errInputClosed)
+	}
+}
+
+func TestReadPastedTextCRLF(t *testing.T) {
+	reader := bufio.NewReader(strings.NewReader("first\r\nsecond\r\n/end\r\nnext\r\n"))
+	got, err := readPastedText(io.Discard, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "first\r\nsecond"; got != want {
+		t.Errorf("readPastedText() = %q, want %q", got, want)
+	}
+	if next, _ := readUserInput(io.Discard, reader, ""); next != "next" {
+		t.Errorf("input after the paste sentinel = %q, want %q", next, "next")
+	}
+}
+
+func TestNormalizeRole(t *testing.T) {
+	tests := []struct {
+		role MsgRole
+		want MsgRole
+	}{
+		{"user", USER},
+		{"User", USER},
+		{"Human", USER},
+		{" AI ", ASSISTANT},
+		{"model", ASSISTANT},
+		{"SYSTEM", SYSTEM},
+	}
+	for _, test := range tests {
+		got, err := normalizeRole(test.role)
+		if err != nil || got != test.want {
+			t.Errorf("normalizeRole(%q) = %q, %v, want %q", test.role, got, err, test.want)
+		}
+	}
+
+	if got, err := normalizeRole("narrator"); err == nil {
+		t.Errorf("normalizeRole(%q) = %q, want an error", "narrator", got)
+	}
+}
+
+func TestRetryDelayBounds(t *testing.T) {
+	for _, jitter := range []float64{0, 0.5, 1} {
+		for _, retry := range []int{0, 1, 2, 5, maxRetryDoublings, 10, 63, 64, 1000} {
+			full := retryBaseDelay << min(retry, maxRetryDoublings)
+			lowest := full - time.Duration(jitter*float64(full))
+			for range 100 {
+				if delay := retryDelay(retry, jitter); delay < lowest || delay > full || delay < 0 {
+					t.Fatalf("retryDelay(%d, %v) = %v, want between %v and %v", retry, jitter, delay, lowest, full)
+				}
+			}
+		}
+	}
+
+	if got, want := retryDelay(1000, 0), retryBaseDelay<<maxRetryDoublings; got != want {
+		t.Errorf("retryDelay(1000, 0) = %v, want the capped delay %v", got, want)
+	}
+}
+
+func TestResponseTokenLimit(t *testing.T) {
+	tests := []struct {
+		model      string
+		completion bool
+	}{
+		{"gpt-4o", false},
+		{"gpt-4.1", false},
+		{"gpt-5", true},
+		{"gpt-5-mini", true},
+		{"gpt-5.1", true},
+		{"GPT-5.1-Codex", true},
+		{"openai/gpt-5.1", true},
+		{"o3", true},
+		{"o3-mini", true},
+		{"o1.5", true},
+		{"o30", false},
+		{"gpt-50", false},
+		{"llama3-o1", false},
+	}
+	cfg := &Config{MaxTokens: 100}
+	for _, test := range tests {
+		limit, completion := responseTokenLimit(cfg, test.model)
+		if limit != 100 || completion != test.completion {
+			t.Errorf("responseTokenLimit(%q) = %d, %v, want 100, %v", test.model, limit, completion, test.completion)
+		}
+	}
+
+	cfg.MaxCompletionTokens = 50
+	if limit, completion := responseTokenLimit(cfg, "gpt-4o"); limit != 50 || !completion {
+		t.Errorf("responseTokenLimit() with --max-completion-tokens = %d, %v, want 50, true", limit, completion)
+	}
+}
+
+func TestInitScreenWidth(t *testing.T) {
+	messages := []Message{{Role: SYSTEM, Content: "Be brief."}, {Role: USER, Content: "Hello"}}
+	sourceFiles := [][]string{{"prompts/système/" + strings.Repeat("é", 60) + ".md", "short.md"}, nil}
+	var out strings.Builder
+	displayInitScreen(&out, messages, sourceFiles, "gpt-4o", 0.7)
+
+	for _, line := range strings.Split(out.String(), "\n") {
+		if !strings.HasPrefix(line, "|") && !strings.HasPrefix(line, "+") {
+			continue
+		}
+		if width := utf8.RuneCountInString(line); width != 52 || !utf8.ValidString(line) {
+			t.Errorf("banner line %q is %d characters wide, want 50 between the borders", line, width)
+		}
+	}
+	for _, command := range []string{"/export", "/export-range", "/note", "/tag", "/pasteclip"} {
+		if !strings.Contains(out.String(), ">> "+command+" ") {
+			t.Errorf("banner doesn't list %s", command)
+		}
+	}
+}
+
+// windowsReservedChars are the characters Windows rejects in file names,
+// besides the control characters.
+const windowsReservedChars = `<>:"/\|?*`
+
+func checkWindowsFileName(t *testing.T, name string) {
+	t.Helper()
+	if strings.ContainsAny(name, windowsReservedChars) || strings.ContainsFunc(name, unicode.IsControl) {
+		t.Errorf("file name %q contains characters Windows rejects", name)
+	}
+}
+
+func TestLogNamesAreValidOnWindows(t *testing.T) {
+	seed := 42
+	messages := []Message{{Role: USER, Content: "Hello: world?"}}
+	for _, metadata := range []LogMetadata{
+		{Model: "gpt-4o"},
+		{Model: "meta-llama/Llama-3:8b", Config: &SavedConfig{LogNaming: hashLogNaming}},
+		{Model: "gpt-4o", Config: &SavedConfig{LogNaming: hashLogNaming, Seed: &seed}},
+	} {
+		checkWindowsFileName(t, logName(messages, metadata)+logFileSuffix(jsonLogFormat))
+	}
+}
+
+func TestBatchDirNameIsValidOnWindows(t *testing.T) {
+	t.Setenv("LLM_MODEL", "")
+	batchDir, logsDir := t.TempDir(), t.TempDir()
+	if err := os.WriteFile(filepath.Join(batchDir, "first.json"), []byte(`[{"role": "user", "content": "Hi"}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadConfig([]string{"--provider", mockProvider, "--batch", batchDir, "--logs-dir", logsDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewSession(cfg, strings.NewReader(""), io.Discard).Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := os.ReadDir(filepath.Join(logsDir, "batch"))
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("batch output directories = %v, %v, want one", entries, err)
+	}
+	checkWindowsFileName(t, entries[0].Name())
+}
+
+// sequenceTransport answers each request with the next of its bodies.
+type sequenceTransport struct {
+	bodies []string
+}
+
+func (t *sequenceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body := t.bodies[0]
+	t.bodies = t.bodies[1:]
+	return mockResponse(req, "application/json", body), nil
+}
+
+func TestSendChatRequestDropsEarlierUsage(t *testing.T) {
+	t.Setenv("LLM_MODEL", "")
+	cfg, err := LoadConfig([]string{"--provider", mockProvider, "--max-retries", "0", "--logs-dir", t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	good := `{"choices": [{"message": {"role": "assistant", "content": "Hi"}, "finish_reason": "stop"}], "usage": {"prompt_tokens": 3, "completion_tokens": 1}}`
+	malformed := []string{
+		`{"choices": [{"message": {"role": "assistant", "content": "Hi"`,
+		// Decoding stops at the choices, after the usage is filled.
+		`{"usage": {"prompt_tokens": 3, "completion_tokens": 1}, "choices": "Hi"}`,
+	}
+
+	for _, body := range malformed {
+		client := &http.Client{Transport: &sequenceTransport{bodies: []string{good, body}}}
+		payload := RequestPayload{Model: cfg.Model, Messages: []Message{{Role: USER, Content: "Hello"}}}
+		var responseBody ResponseBody
+		if _, err := sendChatRequest(io.Discard, client, cfg, payload, &responseBody); err != nil || responseBody.Usage == nil {
+			t.Fatalf("sendChatRequest() = %v with usage %v, want the usage of the good response", err, responseBody.Usage)
+		}
+
+		if _, err := sendChatRequest(io.Discard, client, cfg, payload, &responseBody); err == nil {
+			t.Errorf("sendChatRequest() of %s succeeded, want an error", body)
+		}
+		if responseBody.Usage != nil || len(responseBody.Choices) != 0 {
+			t.Errorf("after the malformed body %s, responseBody = %+v, want it empty", body, responseBody)
+		}
+	}
+}
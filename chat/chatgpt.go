@@ -0,0 +1,174 @@
+package chat
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// chatGPTImportFormat imports the conversations exported from ChatGPT, from
+// the conversations.json file of the export or a single conversation of it.
+const chatGPTImportFormat = "chatgpt"
+
+// chatGPTConversation is a conversation of a ChatGPT export. Its messages
+// form a tree, branching wherever a message was edited or a response
+// regenerated, whose current node is the last message of the branch shown.
+type chatGPTConversation struct {
+	Mapping     map[string]chatGPTNode `json:"mapping"`
+	CurrentNode string                 `json:"current_node"`
+}
+
+type chatGPTNode struct {
+	Message  *chatGPTMessage `json:"message"`
+	Parent   string          `json:"parent"`
+	Children []string        `json:"children"`
+}
+
+type chatGPTMessage struct {
+	Author struct {
+		Role string `json:"role"`
+	} `json:"author"`
+	Content struct {
+		ContentType string `json:"content_type"`
+		// Parts are strings for text, and objects for attachments such
+		// as images.
+		Parts []any `json:"parts"`
+	} `json:"content"`
+	// Recipient is "all" for the messages of the conversation, and the
+	// name of the tool for the calls the assistant made to its tools.
+	Recipient string `json:"recipient"`
+	Metadata  struct {
+		Hidden bool `json:"is_visually_hidden_from_conversation"`
+	} `json:"metadata"`
+}
+
+// importMessages decodes the messages of a conversation exported from
+// another tool in the given format.
+func importMessages(format string, data []byte) ([]MessageIn, error) {
+	switch format {
+	case chatGPTImportFormat:
+		return importChatGPT(data)
+	}
+	return nil, fmt.Errorf("unknown import format \"%s\"", format)
+}
+
+// importChatGPT decodes the messages along the current branch of a ChatGPT
+// conversation, given on its own or as the single conversation of an export.
+// Only the text of the messages is imported, without the hidden ones, like
+// the empty system message at the root of the tree, and without the tool
+// calls and results.
+func importChatGPT(data []byte) ([]MessageIn, error) {
+	var conversation chatGPTConversation
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		var conversations []chatGPTConversation
+		if err := json.Unmarshal(data, &conversations); err != nil {
+			return nil, err
+		}
+		if len(conversations) != 1 {
+			return nil, fmt.Errorf("the export contains %d conversations, import one of them, e.g. extracted with jq '.[0]'", len(conversations))
+		}
+		conversation = conversations[0]
+	} else if err := json.Unmarshal(data, &conversation); err != nil {
+		return nil, err
+	}
+	if len(conversation.Mapping) == 0 {
+		return nil, fmt.Errorf("the conversation has no mapping of messages")
+	}
+
+	var messagesIn []MessageIn
+	for _, node := range chatGPTBranch(conversation) {
+		if msg, ok := chatGPTMessageIn(node.Message); ok {
+			messagesIn = append(messagesIn, msg)
+		}
+	}
+	if len(messagesIn) == 0 {
+		return nil, fmt.Errorf("the conversation has no text messages to import")
+	}
+	return messagesIn, nil
+}
+
+// chatGPTBranch returns the nodes from the root of the conversation to its
+// current node. Exports without a current node, or with one missing from the
+// mapping, follow the last child of each node instead, which is the latest
+// edit or regeneration. Parents missing from the mapping end the branch.
+func chatGPTBranch(conversation chatGPTConversation) []chatGPTNode {
+	mapping := conversation.Mapping
+	id := conversation.CurrentNode
+	if _, ok := mapping[id]; !ok {
+		id = chatGPTLastLeaf(mapping)
+	}
+
+	var branch []chatGPTNode
+	visited := map[string]bool{}
+	for node, ok := mapping[id]; ok && !visited[id]; node, ok = mapping[id] {
+		visited[id] = true
+		branch = append(branch, node)
+		id = node.Parent
+	}
+	slices.Reverse(branch)
+	return branch
+}
+
+// chatGPTLastLeaf returns the id of the node reached by following the last
+// child of each node from the root of the mapping, the node without a parent
+// in it.
+func chatGPTLastLeaf(mapping map[string]chatGPTNode) string {
+	id := ""
+	for candidate, node := range mapping {
+		// Map iteration is random, so ties between several roots are
+		// broken by their id.
+		if _, ok := mapping[node.Parent]; !ok && (id == "" || candidate < id) {
+			id = candidate
+		}
+	}
+
+	visited := map[string]bool{}
+	for !visited[id] {
+		visited[id] = true
+		children := mapping[id].Children
+		next := ""
+		for i := len(children) - 1; i >= 0 && next == ""; i-- {
+			if _, ok := mapping[children[i]]; ok {
+				next = children[i]
+			}
+		}
+		if next == "" {
+			break
+		}
+		id = next
+	}
+	return id
+}
+
+// chatGPTMessageIn converts a message of the conversation, reporting false
+// for the ones that aren't imported.
+func chatGPTMessageIn(message *chatGPTMessage) (MessageIn, bool) {
+	if message == nil || message.Metadata.Hidden {
+		return MessageIn{}, false
+	}
+	if message.Recipient != "" && message.Recipient != "all" {
+		return MessageIn{}, false
+	}
+	switch message.Content.ContentType {
+	case "text", "multimodal_text":
+	default:
+		return MessageIn{}, false
+	}
+	role, err := normalizeRole(MsgRole(message.Author.Role))
+	if err != nil {
+		return MessageIn{}, false
+	}
+
+	var parts []string
+	for _, part := range message.Content.Parts {
+		if text, ok := part.(string); ok && strings.TrimSpace(text) != "" {
+			parts = append(parts, text)
+		}
+	}
+	if len(parts) == 0 {
+		return MessageIn{}, false
+	}
+	return MessageIn{Role: role, Content: strings.Join(parts, "\n\n")}, true
+}
@@ -0,0 +1,45 @@
+package chat
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// clipboardCommands are the tools that print the clipboard contents, in
+// order of preference, for each platform.
+var clipboardCommands = map[string][][]string{
+	"darwin":  {{"pbpaste"}},
+	"windows": {{"powershell.exe", "-NoProfile", "-Command", "Get-Clipboard -Raw"}},
+	"linux": {
+		{"wl-paste", "--no-newline"},
+		{"xclip", "-selection", "clipboard", "-o"},
+		{"xsel", "--clipboard", "--output"},
+	},
+}
+
+// readClipboard returns the text in the system clipboard, using the first
+// clipboard tool available on the platform.
+func readClipboard() (string, error) {
+	commands, ok := clipboardCommands[runtime.GOOS]
+	if !ok {
+		commands = clipboardCommands["linux"]
+	}
+
+	var tools []string
+	for _, command := range commands {
+		tools = append(tools, command[0])
+		if _, err := exec.LookPath(command[0]); err != nil {
+			continue
+		}
+
+		output, err := exec.Command(command[0], command[1:]...).Output()
+		if err != nil {
+			return "", fmt.Errorf("failed to read the clipboard with %s: %w", command[0], err)
+		}
+		return strings.TrimRight(strings.ReplaceAll(string(output), "\r\n", "\n"), "\n"), nil
+	}
+
+	return "", fmt.Errorf("no clipboard tool found, install one of: %s", strings.Join(tools, ", "))
+}
@@ -0,0 +1,46 @@
+package chat
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// compareModels sends the conversation up to its last user message to model,
+// printing the answer after the one the session got for the same prompt. The
+// conversation is left untouched, so comparisons never end up in the history.
+func compareModels(out io.Writer, client *http.Client, cfg *Config, messages []Message, model string) error {
+	last := len(messages) - 1
+	for last >= 0 && messages[last].Role != USER {
+		last--
+	}
+	if last < 0 {
+		return fmt.Errorf("there is no user message to compare")
+	}
+	if err := checkModelAllowed(cfg.ModelAllowlist, model); err != nil {
+		return err
+	}
+
+	payload := RequestPayload{
+		Model:       model,
+		Messages:    messages[:last+1],
+		Temperature: float32(cfg.Temperature),
+	}
+	fmt.Fprintf(out, "Sending the last prompt to %s...\n", model)
+	alternate, usage, err := completeConversation(out, client, cfg, payload)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "\n=== %s ===\n\n", cfg.Model)
+	if last+1 < len(messages) {
+		fmt.Fprintf(out, "%s%s\n", cfg.AssistantPrefix, displayedContent(out, cfg, messages[last+1].Content))
+	} else {
+		fmt.Fprintln(out, "[no answer yet]")
+	}
+
+	fmt.Fprintf(out, "\n=== %s ===\n\n", model)
+	fmt.Fprintf(out, "%s%s\n", cfg.AssistantPrefix, displayedContent(out, cfg, alternate.Content))
+	printUsage(out, usage)
+	return nil
+}
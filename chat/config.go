@@ -0,0 +1,747 @@
+package chat
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+// Config holds the settings of a Session. LoadConfig builds it from the
+// command-line flags and the environment.
+type Config struct {
+	APIKey            string
+	Model             string
+	URL               string
+	Temperature       float64
+	InputFile         string
+	InputDir          string
+	PromptsDir        string
+	LogsDir           string
+	ConfirmQuit       bool
+	SaveOnExit        bool
+	Stream            bool
+	NoSystemFileFatal bool
+	Prefill           bool
+	PreProcess        string
+	PostProcess       string
+	TemperatureRange  []float64
+	Tokenizer         string
+	JSONLEvents       bool
+	Once              bool
+	MaxRetries        int
+	UserPrefix        string
+	AssistantPrefix   string
+	Choices           int
+	AutoRerank        bool
+	Trace             bool
+	Messages          string
+	DiffFiles         []string
+	PromptDelimiter   string
+	BatchDir          string
+	BatchOutput       string
+	Concurrency       int
+	RequestsPerMinute int
+	CACert            string
+	Insecure          bool
+	ClientCert        string
+	ClientKey         string
+	BasicAuthUser     string
+	BasicAuthPassword string
+	ReplayFile        string
+	CountOnly         bool
+	InputPrice        float64
+	OutputPrice       float64
+	Tee               string
+	Window            int
+	Provider          string
+	MockResponse      string
+	SessionTimeout    time.Duration
+	StripThinking     string
+	ExportFormat      string
+	ConfirmOver       int
+	RecordDir         string
+	ReplayHTTPDir     string
+	ReplySeparator    string
+	ResumeFile        string
+	RetryJitter       float64
+	EndUser           string
+	Pick              bool
+	WaitingText       string
+	TypewriterDelay   time.Duration
+	NoInput           bool
+	RequireInput      bool
+	CacheSystem       bool
+	MaxInputBytes     int64
+	Seed              *int
+	LogNaming         string
+	ModelDefaults     []ModelDefaults
+	// OmitTemperature leaves the temperature out of the requests, for
+	// models that reject it.
+	OmitTemperature bool
+	// fixedParams are the request parameters that were set explicitly, so
+	// that the model defaults don't override them.
+	fixedParams         map[string]bool
+	WarnNoSystem        bool
+	MaxTokens           int
+	AutoContinue        bool
+	MaxContinuations    int
+	ProfileDump         bool
+	KeepPartial         bool
+	InputStdinJSON      bool
+	StopCommands        []string
+	Render              string
+	ReasoningEffort     string
+	ThinkingBudget      int
+	Tags                []string
+	TagFilter           string
+	WarnPayloadBytes    int64
+	ModelAllowlist      []string
+	FieldMap            *FieldMap
+	MaxCompletionTokens int
+	ImportFormat        string
+	ImportFile          string
+	settings            []configSetting
+	// warnings are the warnings of LoadConfig, which the session prints to
+	// its output when it starts.
+	warnings configWarnings
+}
+
+// configWarnings collects the warnings written to it, one per write.
+type configWarnings []string
+
+func (w *configWarnings) Write(p []byte) (int, error) {
+	*w = append(*w, strings.TrimRight(string(p), "\n"))
+	return len(p), nil
+}
+
+// lookupFlagValue returns the value given to the flag name in args, in any of
+// the forms accepted by the flag package, without parsing the other flags.
+func lookupFlagValue(args []string, name string) string {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			break
+		}
+
+		trimmed := strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-")
+		if trimmed == arg {
+			continue
+		}
+		if trimmed == name && i+1 < len(args) {
+			return args[i+1]
+		}
+		if value, found := strings.CutPrefix(trimmed, name+"="); found {
+			return value
+		}
+	}
+	return ""
+}
+
+func envOrDefault(key string, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// validateTemperature returns temperature if it is in range. Otherwise, it
+// fails when strict, or clamps it after writing a warning to out.
+func validateTemperature(out io.Writer, temperature float64, strict bool) (float64, error) {
+	if temperature >= minTemperature && temperature <= maxTemperature {
+		return temperature, nil
+	}
+	if strict {
+		return 0, fmt.Errorf("temperature %v is out of range [%v, %v]", temperature, minTemperature, maxTemperature)
+	}
+
+	clamped := math.Max(minTemperature, math.Min(maxTemperature, temperature))
+	warnf(out, "Warning: temperature %v is out of range [%v, %v]. Using %v instead", temperature, minTemperature, maxTemperature, clamped)
+	return clamped, nil
+}
+
+// validateChoices checks that several choices aren't asked for along with
+// streaming, which only shows one of them. The model defaults can set the
+// number of choices as well, so they are checked again once applied.
+func validateChoices(choices int, stream bool) error {
+	if choices > 1 && stream {
+		return fmt.Errorf("--n can't be combined with --stream")
+	}
+	return nil
+}
+
+// validateURL checks that rawURL is an absolute http or https URL, so that
+// typos are reported on startup rather than when the first request fails.
+func validateURL(rawURL string) error {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid --url value \"%s\": %w", rawURL, err)
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return fmt.Errorf("invalid --url value \"%s\": the scheme must be http or https", rawURL)
+	}
+	if parsed.Host == "" {
+		return fmt.Errorf("invalid --url value \"%s\": missing host", rawURL)
+	}
+	return nil
+}
+
+// LoadConfig parses the command-line arguments args, without the program
+// name, into a validated Config. The defaults of the flags come from the
+// environment and the env file.
+func LoadConfig(args []string) (*Config, error) {
+	processEnv := environNames()
+	var warnings configWarnings
+
+	// The env file must be loaded before the flags are defined, since their
+	// defaults come from the environment.
+	if envFile := lookupFlagValue(args, "env-file"); envFile != "" {
+		if err := godotenv.Load(envFile); err != nil {
+			warnf(&warnings, "Warning: could not load env file %s: %v", envFile, err)
+		}
+	} else if err := godotenv.Load(); err != nil {
+		warnf(&warnings, "Warning: could not load .env file: %v", err)
+	}
+
+	flags := flag.NewFlagSet("llm-chat-cli", flag.ContinueOnError)
+
+	flags.String("env-file", "", "Path to an env file to load instead of ./.env")
+	replay := flags.String("replay", "", "Re-send each user turn of a conversation log to the model and compare the new answers to the logged ones")
+	diff := flags.String("diff", "", "Compare two conversation logs, given as --diff a.log.json b.log.json, and exit")
+	provider := flags.String("provider", openAIProvider, "Provider to send requests to: openai, for any OpenAI-compatible API, or mock, to reply locally without network access")
+	mockResponseFile := flags.String("mock-response", "", "File with the response for the mock provider to reply with, instead of echoing the last message")
+	recordDir := flags.String("record", "", "Directory to save every HTTP request and response to, for --replay-http")
+	replayHTTPDir := flags.String("replay-http", "", "Directory of responses saved with --record to serve instead of sending requests")
+	apiKey := flags.String("api-key", os.Getenv("LLM_PROVIDER_KEY"), "LLM provider API key")
+	profileDump := flags.Bool("profile-dump", false, "Print the effective configuration, and where each setting came from, and exit")
+	apiKeyCmd := flags.String("api-key-cmd", "", "Shell command that prints the API key, e.g. to read it from a password manager")
+	basicAuth := flags.String("basic-auth", "", "Credentials in the user:password format, to authenticate with basic auth instead of the API key")
+	model := flags.String("model", os.Getenv("LLM_MODEL"), "LLM model name")
+	fieldMapFile := flags.String("field-map", "", "JSON file renaming the fields of requests and responses, for gateways with non-standard field names")
+	modelAllowlist := flags.String("model-allowlist", os.Getenv("LLM_MODEL_ALLOWLIST"), "File listing the models that may be used, one per line (default: any model)")
+	url := flags.String("url", os.Getenv("CHAT_COMPLETION_URL"), "Chat completion URL")
+	envTemperature := defaultTemperature
+	if value := os.Getenv("TEMPERATURE"); value != "" {
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			warnf(&warnings, "Warning: failed to parse TEMPERATURE value \"%s\". Using default value instead: %v", value, defaultTemperature)
+		} else {
+			envTemperature = parsed
+		}
+	}
+
+	temperature := flags.Float64("temperature", envTemperature, "Temperature for the LLM")
+	inputFile := flags.String("input", defaultInputFile, "Path to the input messages file. When not given, a missing default file starts an empty conversation")
+	cacheSystem := flags.Bool("cache-system", false, "Mark the system messages as cacheable, for providers that support prompt caching")
+	warnPayloadBytes := flags.Int64("warn-payload-bytes", defaultWarnPayloadBytes, "Warn before sending a request whose payload is larger than this many bytes, or 0 to never warn")
+	maxInputBytes := flags.Int64("max-input-bytes", defaultMaxInputBytes, "Maximum size in bytes of the input file and of each message file, or 0 for no limit")
+	warnNoSystem := flags.Bool("warn-no-system", false, "Warn on startup when the conversation has no system message")
+	noInput := flags.Bool("no-input", false, "Start an empty conversation, without reading the input file")
+	messagesJSON := flags.String("messages", "", "JSON array of input messages, used instead of the input file")
+	importFormat := flags.String("import", "", "Continue a conversation exported from another tool, given as --import chatgpt conversation.json")
+	inputStdinJSON := flags.Bool("input-stdin-json", false, "Read the JSON array of input messages from stdin, then continue the conversation on the terminal")
+	promptDelimiter := flags.String("prompt-delimiter", defaultPromptDelimiter, "Text used to join the fragments of a message composed from several files (\\n and \\t are expanded)")
+	batchDir := flags.String("batch", "", "Directory of input files to send, each as an independent conversation, without prompting")
+	caCert := flags.String("ca-cert", "", "PEM file with a CA certificate to trust, in addition to the system ones, for self-hosted endpoints")
+	clientCert := flags.String("client-cert", "", "PEM file with the client certificate for endpoints that require mutual TLS")
+	clientKey := flags.String("client-key", "", "PEM file with the private key of --client-cert")
+	insecure := flags.Bool("insecure", false, "Skip TLS certificate verification. Insecure: anyone on the network can intercept the API key and conversations")
+	rpm := flags.Int("rpm", 0, "Maximum number of requests to send per minute (default: unlimited)")
+	concurrency := flags.Int("concurrency", 1, "Number of --batch conversations to send in parallel")
+	batchOutput := flags.String("batch-output", "", "Directory for the results of --batch (default: a new directory under the logs directory)")
+	inputDir := flags.String("input-dir", envOrDefault("INPUT_DIR", defaultInputBaseDir), "Directory for input files")
+	promptsDir := flags.String("prompts-dir", envOrDefault("PROMPTS_DIR", defaultPromptsBaseDir), "Directory for prompt files")
+	logsDir := flags.String("logs-dir", envOrDefault("LOGS_DIR", defaultLogsBaseDir), "Directory for log files")
+	strictTemperature := flags.Bool("strict-temperature", false, "Fail instead of clamping when the temperature is out of range")
+	temperatureRange := flags.String("temperature-range", "", "Comma-separated temperatures to send the conversation with, once each, without prompting")
+	preProcess := flags.String("pre-process", "", "Shell command that receives each user message on stdin and outputs the text to send instead")
+	postProcess := flags.String("post-process", "", "Shell command that receives each assistant response on stdin and outputs the text to use instead")
+	noSystemFileFatal := flags.Bool("no-system-file-fatal", false, "Warn and keep the inline content instead of exiting when a message file can't be read")
+	tokenizer := flags.String("tokenizer", defaultTokenizer, "BPE encoding used to count tokens, or \"heuristic\" to estimate them")
+	jsonlEvents := flags.Bool("jsonl-events", false, "Write structured events as JSON lines to stdout, and the chat output to stderr")
+	modelDefaultsFile := flags.String("model-defaults", "", "JSON file with the default temperature, seed and n of the models matching each pattern")
+	maxTokens := flags.Int("max-tokens", 0, "Maximum number of tokens to generate for each response, sent as max_completion_tokens to the models that require it (default: the provider's limit)")
+	maxCompletionTokens := flags.Int("max-completion-tokens", 0, "Like --max-tokens, but always sent as max_completion_tokens, for models that aren't detected as requiring it")
+	reasoningEffort := flags.String("reasoning-effort", "", "How much reasoning models think before answering: low, medium or high, sent as reasoning_effort (default: the provider's)")
+	thinkingBudget := flags.Int("thinking-budget", 0, "Tokens reasoning models may spend thinking, sent as an Anthropic-style thinking budget (default: the provider's)")
+	autoContinue := flags.Bool("auto-continue", false, "Ask the model to continue responses truncated by --max-tokens, joining the parts into one message")
+	maxContinuations := flags.Int("max-continuations", defaultMaxContinuations, "Maximum number of times --auto-continue continues a response")
+	seedValue := flags.Int("seed", 0, "Seed sent with each request, for providers that support deterministic sampling")
+	logNaming := flags.String("log-naming", timestampLogNaming, "How conversation logs are named: timestamp, or hash of the model, seed and first prompt")
+	endUser := flags.String("end-user", os.Getenv("LLM_END_USER"), "ID of the end user sent with each request, for providers that track abuse per user")
+	maxRetries := flags.Int("max-retries", defaultMaxRetries, "Maximum number of times to retry a request whose response is malformed or whose stream is interrupted")
+	keepPartial := flags.Bool("keep-partial", false, "Keep the content received so far when a stream is still interrupted after --max-retries, instead of failing the request")
+	retryJitter := flags.Float64("retry-jitter", defaultRetryJitter, "Fraction of each retry delay that is randomized, from 0 (fixed delays) to 1 (any delay up to the full one)")
+	userPrefix := flags.String("user-prefix", defaultUserPrefix, "Prompt shown before user input")
+	assistantPrefix := flags.String("assistant-prefix", defaultAssistantPrefix, "Prefix shown before assistant responses")
+	typewriterDelay := flags.Int("typewriter-delay", 0, "Delay in milliseconds between the characters of streamed responses, to slow them down for demos (default: no delay)")
+	waitingText := flags.String("waiting-text", "", "Text shown while waiting for a response, e.g. \"Thinking...\", and erased once it arrives")
+	replySeparator := flags.String("reply-separator", "", "Line shown after each assistant response and its token usage, e.g. a horizontal rule")
+	choices := flags.Int("n", 1, "Number of answers to request for each message")
+	autoRerank := flags.Bool("auto-rerank", false, "Ask the model to pick the best of the --n answers")
+	trace := flags.Bool("trace", false, "Send a unique X-Request-Id header with each request and log it")
+	stream := flags.Bool("stream", false, "Stream responses as they are generated")
+	prefill := flags.Bool("prefill", false, "Send a trailing assistant message as a prefill for the model to continue")
+	saveOnExit := flags.Bool("save-on-exit", false, "Save the conversation when the session ends without a stop command")
+	stopCommands := flags.String("stop-commands", strings.Join(defaultStopCommands, ","), "Comma-separated inputs that save the conversation and end the session, each followed by ! to end it without saving")
+	once := flags.Bool("once", false, "Exit after the first response instead of prompting for more input")
+	confirmQuit := flags.Bool("confirm-quit", false, "Ask for confirmation before quitting without saving new messages")
+
+	confirmOver := flags.Int("confirm-over", 0, "Ask for confirmation before sending a message when the prompt is estimated to be over this many tokens (default: never)")
+	window := flags.Int("window", 0, "Number of most recent non-system messages to send with each request (default: all)")
+	sessionTimeout := flags.Duration("session-timeout", 0, "Maximum duration of the session, e.g. 30m, after which the conversation is saved and the session ends once the current turn completes (default: no limit)")
+	render := flags.String("render", rawRender, "How responses are displayed: raw, or markdown to style their Markdown on the terminal")
+	stripThinking := flags.String("strip-thinking", "", "Remove <think> blocks from the assistant responses: display, log (the conversation history and logs) or both")
+	exportFormat := flags.String("export-format", jsonLogFormat, "Format of the saved conversation logs: json or yaml")
+	tee := flags.String("tee", "", "File to append each response to as it arrives, in addition to displaying it")
+	inputPrice := flags.Float64("input-price", 0, "Price in USD per million input tokens, to estimate the session cost in /stats")
+	outputPrice := flags.Float64("output-price", 0, "Price in USD per million output tokens, to estimate the session cost in /stats")
+	countOnly := flags.Bool("count-only", false, "Print the token count of the input messages and exit, without sending them")
+	resume := flags.String("resume", "", "Conversation log to continue, instead of the input file. Its model and temperature are used unless given as flags")
+	pick := flags.Bool("pick", false, "Pick a recent conversation log to continue on startup, instead of the input file")
+	tags := flags.String("tags", "", "Comma-separated tags saved in the metadata of the conversation log, to find it later with --tag")
+	tagFilter := flags.String("tag", "", "Only offer the conversation logs with this tag in --pick and /load")
+	fromConfig := flags.String("from-config", "", "Restore the model, URL and sampling settings saved with a conversation log. Flags given explicitly take precedence")
+
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *diff != "" {
+		if flags.NArg() != 1 {
+			return nil, fmt.Errorf("--diff needs two conversation logs: --diff a.log.json b.log.json")
+		}
+		return &Config{DiffFiles: []string{*diff, flags.Arg(0)}, warnings: warnings}, nil
+	}
+
+	importFile := ""
+	if *importFormat != "" {
+		if *importFormat != chatGPTImportFormat {
+			return nil, fmt.Errorf("invalid --import format \"%s\": must be %s", *importFormat, chatGPTImportFormat)
+		}
+		if flags.NArg() != 1 {
+			return nil, fmt.Errorf("--import needs a format and a file: --import chatgpt conversation.json")
+		}
+		importFile = flags.Arg(0)
+	}
+
+	explicit := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	// Parameters restored from a conversation log take precedence over the
+	// model defaults, like the flags given explicitly. Settings missing from
+	// the log, like the model of a log saved without one or a seed that
+	// wasn't set, aren't restored.
+	restored := map[string]bool{}
+	restore := func(name string, apply func()) {
+		if !explicit[name] {
+			apply()
+			restored[name] = true
+		}
+	}
+
+	if *noInput && explicit["input"] {
+		return nil, fmt.Errorf("--no-input can't be combined with --input")
+	}
+
+	if *inputStdinJSON && (*messagesJSON != "" || *resume != "" || *pick || *noInput) {
+		return nil, fmt.Errorf("--input-stdin-json can't be combined with --messages, --resume, --pick or --no-input")
+	}
+
+	if *importFormat != "" && (*messagesJSON != "" || *inputStdinJSON || *resume != "" || *pick || *noInput) {
+		return nil, fmt.Errorf("--import can't be combined with --messages, --input-stdin-json, --resume, --pick or --no-input")
+	}
+
+	if *pick && *resume != "" {
+		return nil, fmt.Errorf("--pick can't be combined with --resume")
+	}
+
+	if *resume != "" {
+		// Logs saved by other tools come without metadata, and are resumed
+		// with the settings from the flags and environment.
+		metadata, err := readLogMetadata(*resume)
+		if err != nil {
+			warnf(&warnings, "Warning: resuming without the model and temperature of the log: %v", err)
+		} else {
+			if metadata.Model != "" {
+				restore("model", func() { *model = metadata.Model })
+			}
+			restore("temperature", func() { *temperature = metadata.Temperature })
+			restore("tags", func() { *tags = strings.Join(metadata.Tags, ",") })
+		}
+	}
+
+	var seed *int
+	if *fromConfig != "" {
+		metadata, err := readLogMetadata(*fromConfig)
+		if err != nil {
+			return nil, err
+		}
+		if metadata.Config == nil {
+			return nil, fmt.Errorf("no configuration saved with %s", *fromConfig)
+		}
+
+		saved := metadata.Config
+		if metadata.Model != "" {
+			restore("model", func() { *model = metadata.Model })
+		}
+		restore("temperature", func() { *temperature = metadata.Temperature })
+		if saved.URL != "" {
+			restore("url", func() { *url = saved.URL })
+		}
+		restore("n", func() { *choices = saved.Choices })
+		restore("auto-rerank", func() { *autoRerank = saved.AutoRerank })
+		restore("stream", func() { *stream = saved.Stream })
+		restore("prefill", func() { *prefill = saved.Prefill })
+		restore("max-retries", func() { *maxRetries = saved.MaxRetries })
+		restore("tokenizer", func() { *tokenizer = saved.Tokenizer })
+		restore("prompt-delimiter", func() { *promptDelimiter = saved.PromptDelimiter })
+		if saved.Seed != nil {
+			restore("seed", func() { seed = saved.Seed })
+		}
+		restore("max-tokens", func() { *maxTokens = saved.MaxTokens })
+		restore("max-completion-tokens", func() { *maxCompletionTokens = saved.MaxCompletionTokens })
+		restore("reasoning-effort", func() { *reasoningEffort = saved.ReasoningEffort })
+		restore("thinking-budget", func() { *thinkingBudget = saved.ThinkingBudget })
+	}
+
+	if *apiKeyCmd != "" {
+		if *basicAuth != "" {
+			return nil, fmt.Errorf("--api-key-cmd can't be combined with --basic-auth")
+		}
+		// A key given explicitly as a flag takes precedence over the
+		// command, which takes precedence over the environment.
+		if !explicit["api-key"] {
+			output, err := runHookCommand(*apiKeyCmd, "")
+			if err != nil {
+				return nil, fmt.Errorf("--api-key-cmd failed: %w", err)
+			}
+			*apiKey = strings.TrimSpace(output)
+			if *apiKey == "" {
+				return nil, fmt.Errorf("--api-key-cmd printed no API key")
+			}
+		}
+	}
+
+	var basicAuthUser, basicAuthPassword string
+	if *basicAuth != "" {
+		if explicit["api-key"] {
+			return nil, fmt.Errorf("--basic-auth can't be combined with --api-key")
+		}
+
+		var found bool
+		basicAuthUser, basicAuthPassword, found = strings.Cut(*basicAuth, ":")
+		if !found || basicAuthUser == "" {
+			return nil, fmt.Errorf("invalid --basic-auth value: must be in the user:password format")
+		}
+		*apiKey = ""
+	}
+
+	if *provider != openAIProvider && *provider != mockProvider {
+		return nil, fmt.Errorf("invalid --provider value \"%s\": must be %s or %s", *provider, openAIProvider, mockProvider)
+	}
+
+	var mockResponse string
+	if *provider == mockProvider {
+		if *mockResponseFile != "" {
+			responseData, err := os.ReadFile(*mockResponseFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read mock response file: %w", err)
+			}
+			mockResponse = strings.TrimRight(string(responseData), "\r\n")
+		}
+		if *model == "" {
+			*model = mockModel
+		}
+		if *url == "" {
+			*url = mockURL
+		}
+	}
+
+	if *recordDir != "" && *replayHTTPDir != "" {
+		return nil, fmt.Errorf("--record can't be combined with --replay-http")
+	}
+
+	// Counting tokens doesn't send any request, and neither the mock provider
+	// nor replayed responses contact any server, so no API settings are
+	// needed. Neither are they to print the configuration.
+	offline := *profileDump || *countOnly || *provider == mockProvider || *replayHTTPDir != ""
+	if *replayHTTPDir != "" && *url == "" {
+		*url = mockURL
+	}
+	if !offline {
+		if *apiKey == "" && basicAuthUser == "" {
+			return nil, fmt.Errorf("missing LLM provider API key. Use --api-key flag or LLM_PROVIDER_KEY env var")
+		}
+		if *url == "" {
+			return nil, fmt.Errorf("missing chat completion URL. Use --url flag or CHAT_COMPLETION_URL env var")
+		}
+	}
+	if *url != "" {
+		if err := validateURL(*url); err != nil {
+			return nil, err
+		}
+	}
+
+	var allowedModels []string
+	if *modelAllowlist != "" {
+		var err error
+		allowedModels, err = readModelAllowlist(*modelAllowlist)
+		if err != nil {
+			return nil, err
+		}
+		if *model != "" {
+			if err := checkModelAllowed(allowedModels, *model); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var fieldMap *FieldMap
+	if *fieldMapFile != "" {
+		var err error
+		if fieldMap, err = readFieldMap(*fieldMapFile); err != nil {
+			return nil, err
+		}
+	}
+
+	validTemperature, err := validateTemperature(&warnings, *temperature, *strictTemperature)
+	if err != nil {
+		return nil, err
+	}
+	*temperature = validTemperature
+
+	if *maxRetries < 0 {
+		return nil, fmt.Errorf("invalid --max-retries value %d: must not be negative", *maxRetries)
+	}
+
+	var modelDefaults []ModelDefaults
+	if *modelDefaultsFile != "" {
+		modelDefaults, err = readModelDefaults(*modelDefaultsFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	fixedParams := map[string]bool{}
+	for _, name := range []string{"temperature", "seed", "n"} {
+		fixedParams[name] = explicit[name] || restored[name]
+	}
+
+	if *logNaming != timestampLogNaming && *logNaming != hashLogNaming {
+		return nil, fmt.Errorf("invalid --log-naming value \"%s\": must be %s or %s", *logNaming, timestampLogNaming, hashLogNaming)
+	}
+
+	if explicit["seed"] {
+		seed = seedValue
+	}
+
+	switch *reasoningEffort {
+	case "", "low", "medium", "high":
+	default:
+		return nil, fmt.Errorf("invalid --reasoning-effort value \"%s\": must be low, medium or high", *reasoningEffort)
+	}
+	if *thinkingBudget != 0 && *thinkingBudget < minThinkingBudget {
+		return nil, fmt.Errorf("invalid --thinking-budget value %d: must be at least %d", *thinkingBudget, minThinkingBudget)
+	}
+	// Each flag targets a different API, which rejects the other's field.
+	if *reasoningEffort != "" && *thinkingBudget != 0 {
+		return nil, fmt.Errorf("--reasoning-effort can't be combined with --thinking-budget")
+	}
+
+	if *warnPayloadBytes < 0 {
+		return nil, fmt.Errorf("invalid --warn-payload-bytes value %d: must not be negative", *warnPayloadBytes)
+	}
+
+	if *maxTokens < 0 {
+		return nil, fmt.Errorf("invalid --max-tokens value %d: must not be negative", *maxTokens)
+	}
+	if *maxCompletionTokens < 0 {
+		return nil, fmt.Errorf("invalid --max-completion-tokens value %d: must not be negative", *maxCompletionTokens)
+	}
+	if *maxTokens > 0 && *maxCompletionTokens > 0 {
+		return nil, fmt.Errorf("--max-tokens can't be combined with --max-completion-tokens")
+	}
+
+	if *maxContinuations < 0 {
+		return nil, fmt.Errorf("invalid --max-continuations value %d: must not be negative", *maxContinuations)
+	}
+
+	if *maxInputBytes < 0 {
+		return nil, fmt.Errorf("invalid --max-input-bytes value %d: must not be negative", *maxInputBytes)
+	}
+
+	if *typewriterDelay < 0 {
+		return nil, fmt.Errorf("invalid --typewriter-delay value %d: must not be negative", *typewriterDelay)
+	}
+
+	if *retryJitter < 0 || *retryJitter > 1 {
+		return nil, fmt.Errorf("invalid --retry-jitter value %.2f: must be between 0 and 1", *retryJitter)
+	}
+
+	if *choices < 1 {
+		return nil, fmt.Errorf("invalid --n value %d: must be at least 1", *choices)
+	}
+	if err := validateChoices(*choices, *stream); err != nil {
+		return nil, err
+	}
+
+	if (*clientCert == "") != (*clientKey == "") {
+		return nil, fmt.Errorf("--client-cert and --client-key must be used together")
+	}
+
+	if *sessionTimeout < 0 {
+		return nil, fmt.Errorf("invalid --session-timeout value %s: must not be negative", *sessionTimeout)
+	}
+
+	if *render != rawRender && *render != markdownRender {
+		return nil, fmt.Errorf("invalid --render value \"%s\": must be %s or %s", *render, rawRender, markdownRender)
+	}
+
+	switch *stripThinking {
+	case "", stripThinkingDisplay, stripThinkingLog, stripThinkingBoth:
+	default:
+		return nil, fmt.Errorf("invalid --strip-thinking value \"%s\": must be %s, %s or %s", *stripThinking, stripThinkingDisplay, stripThinkingLog, stripThinkingBoth)
+	}
+
+	if *exportFormat != jsonLogFormat && *exportFormat != yamlLogFormat {
+		return nil, fmt.Errorf("invalid --export-format value \"%s\": must be %s or %s", *exportFormat, jsonLogFormat, yamlLogFormat)
+	}
+
+	if *confirmOver < 0 {
+		return nil, fmt.Errorf("invalid --confirm-over value %d: must not be negative", *confirmOver)
+	}
+
+	if *window < 0 {
+		return nil, fmt.Errorf("invalid --window value %d: must not be negative", *window)
+	}
+
+	if *rpm < 0 {
+		return nil, fmt.Errorf("invalid --rpm value %d: must not be negative", *rpm)
+	}
+
+	if *concurrency < 1 {
+		return nil, fmt.Errorf("invalid --concurrency value %d: must be at least 1", *concurrency)
+	}
+
+	var temperatures []float64
+	if *temperatureRange != "" {
+		for _, value := range strings.Split(*temperatureRange, ",") {
+			t, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value \"%s\" in temperature range: %w", value, err)
+			}
+			if t, err = validateTemperature(&warnings, t, *strictTemperature); err != nil {
+				return nil, err
+			}
+			temperatures = append(temperatures, t)
+		}
+	}
+
+	var stops []string
+	for _, command := range strings.Split(*stopCommands, ",") {
+		if command = strings.TrimSpace(command); command != "" {
+			stops = append(stops, command)
+		}
+	}
+	if len(stops) == 0 {
+		return nil, fmt.Errorf("invalid --stop-commands value %q: must list at least one command", *stopCommands)
+	}
+
+	delimiter := strings.NewReplacer(`\n`, "\n", `\t`, "\t").Replace(*promptDelimiter)
+
+	return &Config{
+		APIKey:              *apiKey,
+		Model:               *model,
+		URL:                 *url,
+		Temperature:         *temperature,
+		InputFile:           *inputFile,
+		InputDir:            *inputDir,
+		PromptsDir:          *promptsDir,
+		LogsDir:             *logsDir,
+		ConfirmQuit:         *confirmQuit,
+		SaveOnExit:          *saveOnExit,
+		Stream:              *stream,
+		NoSystemFileFatal:   *noSystemFileFatal,
+		Prefill:             *prefill,
+		PreProcess:          *preProcess,
+		PostProcess:         *postProcess,
+		TemperatureRange:    temperatures,
+		Tokenizer:           *tokenizer,
+		JSONLEvents:         *jsonlEvents,
+		Once:                *once,
+		MaxRetries:          *maxRetries,
+		UserPrefix:          *userPrefix,
+		AssistantPrefix:     *assistantPrefix,
+		Choices:             *choices,
+		AutoRerank:          *autoRerank,
+		Trace:               *trace,
+		Messages:            *messagesJSON,
+		PromptDelimiter:     delimiter,
+		BatchDir:            *batchDir,
+		BatchOutput:         *batchOutput,
+		Concurrency:         *concurrency,
+		RequestsPerMinute:   *rpm,
+		CACert:              *caCert,
+		Insecure:            *insecure,
+		ClientCert:          *clientCert,
+		ClientKey:           *clientKey,
+		BasicAuthUser:       basicAuthUser,
+		BasicAuthPassword:   basicAuthPassword,
+		ReplayFile:          *replay,
+		CountOnly:           *countOnly,
+		InputPrice:          *inputPrice,
+		OutputPrice:         *outputPrice,
+		Tee:                 *tee,
+		Window:              *window,
+		Provider:            *provider,
+		MockResponse:        mockResponse,
+		SessionTimeout:      *sessionTimeout,
+		StripThinking:       *stripThinking,
+		ExportFormat:        *exportFormat,
+		ConfirmOver:         *confirmOver,
+		RecordDir:           *recordDir,
+		ReplayHTTPDir:       *replayHTTPDir,
+		ReplySeparator:      *replySeparator,
+		ResumeFile:          *resume,
+		RetryJitter:         *retryJitter,
+		EndUser:             *endUser,
+		Pick:                *pick,
+		WaitingText:         *waitingText,
+		TypewriterDelay:     time.Duration(*typewriterDelay) * time.Millisecond,
+		NoInput:             *noInput,
+		RequireInput:        explicit["input"],
+		CacheSystem:         *cacheSystem,
+		MaxInputBytes:       *maxInputBytes,
+		Seed:                seed,
+		LogNaming:           *logNaming,
+		ModelDefaults:       modelDefaults,
+		fixedParams:         fixedParams,
+		WarnNoSystem:        *warnNoSystem,
+		MaxTokens:           *maxTokens,
+		AutoContinue:        *autoContinue,
+		MaxContinuations:    *maxContinuations,
+		ProfileDump:         *profileDump,
+		KeepPartial:         *keepPartial,
+		InputStdinJSON:      *inputStdinJSON,
+		StopCommands:        stops,
+		Render:              *render,
+		ReasoningEffort:     *reasoningEffort,
+		ThinkingBudget:      *thinkingBudget,
+		Tags:                addTags(nil, strings.Split(*tags, ",")...),
+		TagFilter:           *tagFilter,
+		WarnPayloadBytes:    *warnPayloadBytes,
+		ModelAllowlist:      allowedModels,
+		FieldMap:            fieldMap,
+		MaxCompletionTokens: *maxCompletionTokens,
+		ImportFormat:        *importFormat,
+		ImportFile:          importFile,
+		warnings:            warnings,
+		settings:            describeSettings(flags, resolvedValues(seed), explicit, restored, processEnv, *apiKeyCmd != "" && !explicit["api-key"]),
+	}, nil
+}
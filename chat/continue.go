@@ -0,0 +1,65 @@
+package chat
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+)
+
+const (
+	// continuePrompt asks the model to carry on with a truncated response.
+	// It is only sent with the continuation requests, and never added to the
+	// conversation.
+	continuePrompt          = "Continue exactly where you stopped, without repeating any of the text you already wrote."
+	defaultMaxContinuations = 3
+)
+
+// continueResponse sends continuation requests for as long as choice is
+// truncated by length, up to cfg.MaxContinuations times, appending each part
+// to it so that it ends up as a single assistant message. usage accumulates
+// the usage of every request, and requestIDs their IDs. On failure, choice
+// keeps the parts received so far.
+func continueResponse(out io.Writer, client *http.Client, cfg *Config, payload RequestPayload, choice *ResponseChoice, usage **Usage, requestIDs *[]string) {
+	// Continuations extend the response being displayed, so they're shown
+	// without the assistant prefix.
+	continueCfg := *cfg
+	continueCfg.AssistantPrefix = ""
+
+	for i := 0; i < cfg.MaxContinuations && choice.FinishReason == finishReasonLength; i++ {
+		fmt.Fprintln(out, styled(out, ansiDim, fmt.Sprintf("[response truncated, continuing (%d/%d)...]", i+1, cfg.MaxContinuations)))
+
+		request := payload
+		request.N = 0
+		request.Messages = append(payload.Messages[:len(payload.Messages):len(payload.Messages)],
+			Message{Role: ASSISTANT, Content: choice.Message.Content},
+			Message{Role: USER, Content: continuePrompt},
+		)
+
+		var responseBody ResponseBody
+		var err error
+		if cfg.Stream {
+			err = streamChatRequest(out, client, &continueCfg, request, &responseBody)
+		} else {
+			_, err = sendChatRequest(out, client, &continueCfg, request, &responseBody)
+		}
+		if err == nil && len(responseBody.Choices) == 0 {
+			err = fmt.Errorf("no response from API")
+		}
+		if err != nil {
+			warnf(out, "Warning: failed to continue the truncated response: %v", err)
+			return
+		}
+
+		next := responseBody.Choices[0]
+		*requestIDs = append(*requestIDs, responseBody.requestIDs...)
+		choice.Message.Content += next.Message.Content
+		choice.FinishReason = next.FinishReason
+		if responseBody.Usage != nil {
+			if *usage == nil {
+				*usage = &Usage{}
+			}
+			(*usage).PromptTokens += responseBody.Usage.PromptTokens
+			(*usage).CompletionTokens += responseBody.Usage.CompletionTokens
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package chat
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// diffConversationLogs prints the differences between two conversation logs,
+// aligning their messages by index. Identical turns are summarized in a
+// single line, while for differing turns the content is compared line by line.
+func diffConversationLogs(out io.Writer, fileA string, fileB string) error {
+	messagesA, err := readConversationLog(fileA)
+	if err != nil {
+		return err
+	}
+	messagesB, err := readConversationLog(fileB)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "--- %s\n+++ %s\n\n", fileA, fileB)
+
+	differences := 0
+	for i := 0; i < max(len(messagesA), len(messagesB)); i++ {
+		switch {
+		case i >= len(messagesA):
+			differences++
+			fmt.Fprintln(out, styled(out, ansiBold, fmt.Sprintf("[%d] only in %s: %s", i, fileB, messagesB[i].Role)))
+			printDiffLines(out, "+ ", ansiGreen, messagesB[i].Content)
+		case i >= len(messagesB):
+			differences++
+			fmt.Fprintln(out, styled(out, ansiBold, fmt.Sprintf("[%d] only in %s: %s", i, fileA, messagesA[i].Role)))
+			printDiffLines(out, "- ", ansiRed, messagesA[i].Content)
+		case messagesA[i] == messagesB[i]:
+			fmt.Fprintf(out, "[%d] %s: identical\n", i, messagesA[i].Role)
+			continue
+		default:
+			differences++
+			role := string(messagesA[i].Role)
+			if messagesA[i].Role != messagesB[i].Role {
+				role = fmt.Sprintf("%s / %s", messagesA[i].Role, messagesB[i].Role)
+			}
+			fmt.Fprintln(out, styled(out, ansiBold, fmt.Sprintf("[%d] %s: differs", i, role)))
+			printContentDiff(out, messagesA[i].Content, messagesB[i].Content)
+		}
+		fmt.Fprintln(out)
+	}
+
+	fmt.Fprintf(out, "%d of %d turns differ\n", differences, max(len(messagesA), len(messagesB)))
+	return nil
+}
+
+func printDiffLines(out io.Writer, marker string, style string, content string) {
+	for _, line := range strings.Split(content, "\n") {
+		fmt.Fprintln(out, styled(out, style, marker+line))
+	}
+}
+
+// printContentDiff prints a line diff of two message contents, based on
+// their longest common subsequence of lines.
+func printContentDiff(out io.Writer, contentA string, contentB string) {
+	linesA := strings.Split(contentA, "\n")
+	linesB := strings.Split(contentB, "\n")
+
+	common := make([][]int, len(linesA)+1)
+	for i := range common {
+		common[i] = make([]int, len(linesB)+1)
+	}
+	for i := len(linesA) - 1; i >= 0; i-- {
+		for j := len(linesB) - 1; j >= 0; j-- {
+			if linesA[i] == linesB[j] {
+				common[i][j] = common[i+1][j+1] + 1
+			} else {
+				common[i][j] = max(common[i+1][j], common[i][j+1])
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(linesA) || j < len(linesB) {
+		switch {
+		case i < len(linesA) && j < len(linesB) && linesA[i] == linesB[j]:
+			fmt.Fprintln(out, "  "+linesA[i])
+			i++
+			j++
+		case i < len(linesA) && (j == len(linesB) || common[i+1][j] >= common[i][j+1]):
+			fmt.Fprintln(out, styled(out, ansiRed, "- "+linesA[i]))
+			i++
+		default:
+			fmt.Fprintln(out, styled(out, ansiGreen, "+ "+linesB[j]))
+			j++
+		}
+	}
+}
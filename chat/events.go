@@ -0,0 +1,46 @@
+package chat
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// eventWriter receives the NDJSON events of a session in --jsonl-events
+// mode.
+type eventWriter struct {
+	// mu serializes writes to w, so events emitted by batch workers don't
+	// interleave.
+	mu sync.Mutex
+	w  io.Writer
+}
+
+type Event struct {
+	Type      string `json:"type"`
+	Timestamp string `json:"timestamp"`
+	Payload   any    `json:"payload"`
+}
+
+// emitEvent writes an event to the events of the session that out is the
+// output of. Events are discarded outside of --jsonl-events mode.
+func emitEvent(out io.Writer, eventType string, payload any) {
+	output, ok := out.(*sessionOutput)
+	if !ok || output.events == nil {
+		return
+	}
+
+	line, err := json.Marshal(Event{
+		Type:      eventType,
+		Timestamp: time.Now().UTC().Format(time.RFC3339Nano),
+		Payload:   payload,
+	})
+	if err != nil {
+		warnf(out, "Error marshalling %s event: %v", eventType, err)
+		return
+	}
+
+	output.events.mu.Lock()
+	defer output.events.mu.Unlock()
+	output.events.w.Write(append(line, '\n'))
+}
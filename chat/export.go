@@ -0,0 +1,110 @@
+package chat
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// markdownExportFormat renders conversations as a readable document. Unlike
+// the log formats, it can't be read back.
+const markdownExportFormat = "md"
+
+var exportFormats = []string{jsonLogFormat, yamlLogFormat, markdownExportFormat, htmlExportFormat}
+
+var roleTitles = map[MsgRole]string{
+	SYSTEM:    "System",
+	USER:      "User",
+	ASSISTANT: "Assistant",
+}
+
+func marshalExport(messages []Message, format string, model string) ([]byte, error) {
+	switch format {
+	case htmlExportFormat:
+		return marshalHTML(messages, model), nil
+	case markdownExportFormat:
+	default:
+		return marshalConversation(messages, format)
+	}
+
+	var document strings.Builder
+	fmt.Fprintf(&document, "# Conversation with %s\n", model)
+	for _, msg := range messages {
+		fmt.Fprintf(&document, "\n## %s\n\n%s\n", roleTitles[msg.Role], strings.TrimRight(msg.Content, "\n"))
+	}
+	return []byte(document.String()), nil
+}
+
+// exportConversation writes messages to fileName in format, or to a new file
+// next to the conversation logs when fileName is empty.
+func exportConversation(out io.Writer, messages []Message, cfg *Config, format string, fileName string) error {
+	content, err := marshalExport(messages, format, cfg.Model)
+	if err != nil {
+		return fmt.Errorf("failed to export conversation: %w", err)
+	}
+
+	if fileName == "" {
+		exportDir := filepath.Join(cfg.LogsDir, sanitizeModelName(cfg.Model))
+		timestamp := time.Now().UTC().Format(logTimestampFormat)
+		fileName = filepath.Join(exportDir, timestamp+".export."+format)
+		for n := 1; fileExists(fileName); n++ {
+			fileName = filepath.Join(exportDir, fmt.Sprintf("%s-%d.export.%s", timestamp, n, format))
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+		return fmt.Errorf("failed to create export directory: %w", err)
+	}
+	if err := os.WriteFile(fileName, content, 0644); err != nil {
+		return fmt.Errorf("failed to save exported conversation: %w", err)
+	}
+
+	fmt.Fprintf(out, "Conversation exported to %s\n", fileName)
+	return nil
+}
+
+// exportRange parses the three arguments of /export-range, "<start> <end>
+// <file>", and exports the messages from start to end, both included and numbered
+// from 0, to the file in the format of its extension.
+func exportRange(out io.Writer, messages []Message, cfg *Config, args string) error {
+	fields := strings.Fields(args)
+	if len(messages) == 0 {
+		return fmt.Errorf("there are no messages to export")
+	}
+
+	start, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return fmt.Errorf("invalid start index %q: %w", fields[0], err)
+	}
+	end, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return fmt.Errorf("invalid end index %q: %w", fields[1], err)
+	}
+	if start < 0 || end < start || end >= len(messages) {
+		return fmt.Errorf("invalid range %d-%d: must be within the %d messages, numbered from 0 to %d", start, end, len(messages), len(messages)-1)
+	}
+
+	fileName := fields[2]
+	format := strings.TrimPrefix(strings.ToLower(filepath.Ext(fileName)), ".")
+	if format == "yml" {
+		format = yamlLogFormat
+	}
+	if !isExportFormat(format) {
+		return fmt.Errorf("unsupported file extension %q: must be one of %s", filepath.Ext(fileName), strings.Join(exportFormats, ", "))
+	}
+
+	return exportConversation(out, messages[start:end+1], cfg, format, fileName)
+}
+
+func isExportFormat(format string) bool {
+	for _, supported := range exportFormats {
+		if format == supported {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,104 @@
+package chat
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// FieldMap renames JSON fields of the requests and responses, for gateways
+// whose field names differ slightly from the OpenAI ones. Each mapping goes
+// from the dotted path of a field, e.g. "usage.input_tokens", to its new
+// name. Arrays don't add to the path, so "choices.message" names the message
+// of every choice.
+type FieldMap struct {
+	// Request renames the fields sent, from the OpenAI names.
+	Request map[string]string `json:"request,omitempty"`
+	// Response renames the fields received, to the OpenAI names.
+	Response map[string]string `json:"response,omitempty"`
+}
+
+func readFieldMap(fileName string) (*FieldMap, error) {
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read field map: %w", err)
+	}
+
+	var fieldMap FieldMap
+	if err := json.Unmarshal(stripJSONComments(content), &fieldMap); err != nil {
+		return nil, fmt.Errorf("invalid field map %s: %w", fileName, err)
+	}
+	for _, mapping := range []map[string]string{fieldMap.Request, fieldMap.Response} {
+		for from, to := range mapping {
+			if from == "" || to == "" || strings.Contains(to, ".") {
+				return nil, fmt.Errorf("invalid field map %s: %q -> %q must map a field path to a name without dots", fileName, from, to)
+			}
+		}
+	}
+	return &fieldMap, nil
+}
+
+// remapFields renames the fields of the JSON document data as given by
+// mapping. Numbers are kept as they were written.
+func remapFields(data []byte, mapping map[string]string) ([]byte, error) {
+	if len(mapping) == 0 {
+		return data, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var document any
+	if err := decoder.Decode(&document); err != nil {
+		return nil, err
+	}
+	return json.Marshal(remapValue(document, "", mapping))
+}
+
+func remapValue(value any, path string, mapping map[string]string) any {
+	switch value := value.(type) {
+	case map[string]any:
+		// Renamed fields are set last, replacing any field that already
+		// had their new name.
+		remapped := make(map[string]any, len(value))
+		renamed := map[string]any{}
+		for key, field := range value {
+			fieldPath := key
+			if path != "" {
+				fieldPath = path + "." + key
+			}
+			if name, ok := mapping[fieldPath]; ok {
+				renamed[name] = remapValue(field, fieldPath, mapping)
+			} else {
+				remapped[key] = remapValue(field, fieldPath, mapping)
+			}
+		}
+		for key, field := range renamed {
+			remapped[key] = field
+		}
+		return remapped
+	case []any:
+		for i, item := range value {
+			value[i] = remapValue(item, path, mapping)
+		}
+		return value
+	}
+	return value
+}
+
+// requestFields returns the request mapping of cfg, if any.
+func requestFields(cfg *Config) map[string]string {
+	if cfg.FieldMap == nil {
+		return nil
+	}
+	return cfg.FieldMap.Request
+}
+
+// responseFields returns the response mapping of cfg, if any.
+func responseFields(cfg *Config) map[string]string {
+	if cfg.FieldMap == nil {
+		return nil
+	}
+	return cfg.FieldMap.Response
+}
@@ -0,0 +1,215 @@
+package chat
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// recentLogsLimit is the number of conversations offered by the picker.
+const recentLogsLimit = 10
+
+// listConversationLogs returns the conversation logs in logsDir, most
+// recently saved first.
+func listConversationLogs(logsDir string) ([]string, error) {
+	type savedLog struct {
+		name    string
+		modTime int64
+	}
+
+	var logs []savedLog
+	err := filepath.WalkDir(logsDir, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		if !strings.HasSuffix(name, logFileSuffix(jsonLogFormat)) && !strings.HasSuffix(name, logFileSuffix(yamlLogFormat)) {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		logs = append(logs, savedLog{name: name, modTime: info.ModTime().UnixNano()})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list conversation logs: %w", err)
+	}
+
+	sort.SliceStable(logs, func(i, j int) bool { return logs[i].modTime > logs[j].modTime })
+	names := make([]string, len(logs))
+	for i, saved := range logs {
+		names[i] = saved.name
+	}
+	return names, nil
+}
+
+// pickConversationLog lists the most recent conversation logs, only those
+// tagged with tag unless it is empty, and asks which one to load. It returns
+// an empty name when the user cancels.
+func pickConversationLog(out io.Writer, reader *bufio.Reader, logsDir string, tag string) (string, error) {
+	logs, err := listConversationLogs(logsDir)
+	if err != nil {
+		return "", err
+	}
+	if tag != "" {
+		logs = filterTaggedLogs(logs, tag)
+		if len(logs) == 0 {
+			return "", fmt.Errorf("no conversation logs tagged %q in %s", tag, logsDir)
+		}
+	}
+	if len(logs) == 0 {
+		return "", fmt.Errorf("no conversation logs in %s", logsDir)
+	}
+	if len(logs) > recentLogsLimit {
+		logs = logs[:recentLogsLimit]
+	}
+
+	fmt.Fprintln(out, "Recent conversations:")
+	fmt.Fprintln(out)
+	for i, name := range logs {
+		summary := "unreadable"
+		if messages, err := readConversationLog(name); err == nil {
+			summary = fmt.Sprintf("%d messages", len(messages))
+			for _, msg := range messages {
+				if msg.Role == USER {
+					summary += ": " + truncateLine(msg.Content, 50)
+					break
+				}
+			}
+		}
+
+		relative, err := filepath.Rel(logsDir, name)
+		if err != nil {
+			relative = name
+		}
+		fmt.Fprintf(out, "  %3d) %s (%s)\n", i+1, relative, summary)
+	}
+	fmt.Fprintln(out)
+
+	for {
+		choice, err := readUserInput(out, reader, "Pick a conversation number, or press Enter to cancel: ")
+		if err != nil {
+			return "", err
+		}
+
+		choice = strings.TrimSpace(choice)
+		if choice == "" {
+			return "", nil
+		}
+
+		n, err := strconv.Atoi(choice)
+		if err != nil || n < 1 || n > len(logs) {
+			fmt.Fprintf(out, "!! Enter a number between 1 and %d\n", len(logs))
+			continue
+		}
+
+		return logs[n-1], nil
+	}
+}
+
+// filterTaggedLogs returns the logs whose metadata has tag, ignoring case.
+// Logs without metadata have no tags.
+func filterTaggedLogs(logs []string, tag string) []string {
+	var tagged []string
+	for _, name := range logs {
+		for _, logTag := range logTags(name) {
+			if strings.EqualFold(logTag, tag) {
+				tagged = append(tagged, name)
+				break
+			}
+		}
+	}
+	return tagged
+}
+
+// addTags adds the tags in added to tags, trimmed, skipping empty and
+// repeated ones.
+func addTags(tags []string, added ...string) []string {
+	for _, tag := range added {
+		tag = strings.TrimSpace(tag)
+		if tag != "" && !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// logTags returns the tags in the metadata of a conversation log, if any.
+func logTags(fileName string) []string {
+	metadata, err := readLogMetadata(fileName)
+	if err != nil {
+		return nil
+	}
+	return metadata.Tags
+}
+
+// readResumedLog reads a conversation log to continue it, restoring its
+// notes and request IDs to annotations. A session resumed and saved over and
+// over, or composed by other tools, can repeat its system prompt, so
+// duplicates among the leading system messages are dropped, and the notes and
+// request IDs are moved back to the messages they belonged to.
+func readResumedLog(out io.Writer, annotations *logAnnotations, fileName string) ([]Message, error) {
+	messages, err := readConversationLog(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	deduped, removed := dedupeSystemMessages(messages)
+	if len(removed) > 0 {
+		warnf(out, "Warning: removed %d duplicate system messages from %s", len(removed), fileName)
+	}
+
+	// Logs saved by other tools come without metadata, and so without
+	// notes or request IDs.
+	annotations.notes, annotations.requests = nil, nil
+	if metadata, err := readLogMetadata(fileName); err == nil {
+		annotations.notes = shiftNotes(metadata.Notes, removed, len(deduped))
+		annotations.requests = shiftRequests(metadata.Requests, removed, len(deduped))
+	}
+	return deduped, nil
+}
+
+// shiftIndex returns the index that the message at index, or the count of
+// messages preceding it, becomes once the messages at the removed indices are
+// dropped.
+func shiftIndex(index int, removed []int) int {
+	shifted := index
+	for _, i := range removed {
+		if i < index {
+			shifted--
+		}
+	}
+	return shifted
+}
+
+// dedupeSystemMessages drops the system messages at the start of messages
+// that are identical to an earlier one, keeping the first of each. It also
+// returns the indices of the messages dropped.
+func dedupeSystemMessages(messages []Message) ([]Message, []int) {
+	seen := map[string]bool{}
+	deduped := make([]Message, 0, len(messages))
+	var removed []int
+	for i, msg := range messages {
+		if msg.Role != SYSTEM {
+			return append(deduped, messages[i:]...), removed
+		}
+		if seen[msg.Content] {
+			removed = append(removed, i)
+		} else {
+			seen[msg.Content] = true
+			deduped = append(deduped, msg)
+		}
+	}
+	return deduped, removed
+}
@@ -0,0 +1,79 @@
+package chat
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestDedupeSystemMessages(t *testing.T) {
+	messages := []Message{
+		{Role: SYSTEM, Content: "Be brief."},
+		{Role: SYSTEM, Content: "Answer in English."},
+		{Role: SYSTEM, Content: "Be brief."},
+		{Role: SYSTEM, Content: "Be brief."},
+		{Role: USER, Content: "Hello"},
+		{Role: SYSTEM, Content: "Be brief."},
+		{Role: ASSISTANT, Content: "Hi"},
+	}
+
+	deduped, removed := dedupeSystemMessages(messages)
+	want := []Message{messages[0], messages[1], messages[4], messages[5], messages[6]}
+	if !equalMessages(deduped, want) {
+		t.Errorf("dedupeSystemMessages() = %v, want %v", deduped, want)
+	}
+	if wantRemoved := []int{2, 3}; !slices.Equal(removed, wantRemoved) {
+		t.Errorf("dedupeSystemMessages() removed %v, want %v", removed, wantRemoved)
+	}
+
+	if deduped, removed := dedupeSystemMessages(messages[4:]); !equalMessages(deduped, messages[4:]) || len(removed) != 0 {
+		t.Errorf("dedupeSystemMessages() without leading system messages = %v, %v, want them unchanged", deduped, removed)
+	}
+}
+
+func TestReadResumedLogShiftsMetadata(t *testing.T) {
+	baseName := filepath.Join(t.TempDir(), "conversation")
+	log := `[
+		{"role": "system", "content": "Be brief."},
+		{"role": "system", "content": "Be brief."},
+		{"role": "user", "content": "Hello"},
+		{"role": "assistant", "content": "Hi"}
+	]`
+	metadata := `{"notes": [
+		{"after": 1, "text": "before the duplicate"},
+		{"after": 3, "text": "after the question"},
+		{"after": 4, "text": "at the end"}
+	], "requests": [
+		{"message": 3, "ids": ["req-1", "req-2"]}
+	]}`
+	if err := os.WriteFile(baseName+logFileSuffix(jsonLogFormat), []byte(log), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(baseName+".meta.json", []byte(metadata), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var annotations logAnnotations
+	messages, err := readResumedLog(io.Discard, &annotations, baseName+logFileSuffix(jsonLogFormat))
+	if err != nil {
+		t.Fatal(err)
+	}
+	notes := annotations.notes
+	if len(messages) != 3 {
+		t.Fatalf("readResumedLog() returned %d messages, want 3", len(messages))
+	}
+	wantAfter := map[string]int{"before the duplicate": 1, "after the question": 2, "at the end": 3}
+	if len(notes) != len(wantAfter) {
+		t.Fatalf("readResumedLog() returned %d notes, want %d", len(notes), len(wantAfter))
+	}
+	for _, note := range notes {
+		if note.After != wantAfter[note.Text] {
+			t.Errorf("note %q follows %d messages, want %d", note.Text, note.After, wantAfter[note.Text])
+		}
+	}
+	if len(annotations.requests) != 1 || annotations.requests[0].Message != 2 || !slices.Equal(annotations.requests[0].IDs, []string{"req-1", "req-2"}) {
+		t.Errorf("readResumedLog() restored the requests %v, want req-1 and req-2 for message 2", annotations.requests)
+	}
+}
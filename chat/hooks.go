@@ -0,0 +1,72 @@
+package chat
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// runHookCommand runs command through the system shell, feeding input on
+// stdin, and returns what the command wrote to stdout.
+func runHookCommand(command string, input string) (string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdin = strings.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%w: %s", err, msg)
+		}
+		return "", err
+	}
+
+	return stdout.String(), nil
+}
+
+func postProcess(out io.Writer, cfg *Config, content string) string {
+	if cfg.PostProcess == "" {
+		return content
+	}
+
+	processed, err := runHookCommand(cfg.PostProcess, content)
+	if err != nil {
+		warnf(out, "Warning: post-process command failed, using the original response: %v", err)
+		return content
+	}
+
+	return strings.TrimRight(processed, "\r\n")
+}
+
+// preProcess transforms a user message with the pre-process command. It
+// reports false when the command produced no output and the message should
+// not be sent.
+func preProcess(out io.Writer, cfg *Config, userInput string) (string, bool) {
+	if cfg.PreProcess == "" {
+		return userInput, true
+	}
+
+	processed, err := runHookCommand(cfg.PreProcess, userInput)
+	if err != nil {
+		warnf(out, "Warning: pre-process command failed, using the original message: %v", err)
+		return userInput, true
+	}
+
+	processed = strings.TrimRight(processed, "\r\n")
+	if strings.TrimSpace(processed) == "" {
+		warnf(out, "Warning: pre-process command produced no output, message was not sent")
+		return "", false
+	}
+
+	return processed, true
+}
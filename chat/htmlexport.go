@@ -0,0 +1,138 @@
+package chat
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+)
+
+// htmlExportFormat renders conversations as a standalone web page, with its
+// styles inline so the file can be shared on its own.
+const htmlExportFormat = "html"
+
+const htmlExportStyle = `
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; background: #f4f5f7; color: #1f2328; margin: 0; }
+main { max-width: 860px; margin: 0 auto; padding: 24px 16px; }
+h1 { font-size: 1.3em; }
+.message { margin: 12px 0; padding: 10px 14px; border-radius: 12px; box-shadow: 0 1px 2px rgba(0, 0, 0, 0.08); }
+.message .role { font-size: 0.75em; font-weight: 600; text-transform: uppercase; letter-spacing: 0.05em; opacity: 0.6; margin-bottom: 4px; }
+.message .text { white-space: pre-wrap; overflow-wrap: anywhere; }
+.system { background: #fff8c5; }
+.user { background: #ddf4ff; margin-left: 15%; }
+.assistant { background: #ffffff; margin-right: 15%; }
+code { font-family: ui-monospace, "SF Mono", Menlo, Consolas, monospace; font-size: 0.9em; background: rgba(0, 0, 0, 0.06); padding: 1px 4px; border-radius: 4px; }
+pre { background: #1f2328; color: #e6edf3; padding: 10px 12px; border-radius: 8px; overflow-x: auto; white-space: pre; }
+pre code { background: none; padding: 0; }
+.hl-k { color: #ff7b72; }
+.hl-s { color: #a5d6ff; }
+.hl-c { color: #8b949e; font-style: italic; }
+.hl-n { color: #79c0ff; }
+`
+
+// codeTokens matches the tokens that code blocks highlight, in the order
+// they are tried: comments, strings, keywords and numbers, shared by most
+// languages closely enough for a transcript.
+var codeTokens = regexp.MustCompile(`(?P<c>//[^\n]*|#[^\n]*|/\*[\s\S]*?\*/)` +
+	"|(?P<s>\"(?:[^\"\\\\\\n]|\\\\.)*\"|'(?:[^'\\\\\\n]|\\\\.)*'|`[^`]*`)" +
+	`|(?P<k>\b(?:func|def|fn|function|return|if|else|elif|for|while|switch|case|break|continue|class|struct|interface|type|import|from|package|const|let|var|new|try|catch|except|finally|raise|throw|async|await|yield|in|range|nil|null|None|true|false|True|False|self|this|public|private|static|void|int|string|bool)\b)` +
+	`|(?P<n>\b\d+(?:\.\d+)?\b)`)
+
+// marshalHTML renders messages as a web page, in bubbles colored by role.
+func marshalHTML(messages []Message, model string) []byte {
+	var page strings.Builder
+	title := html.EscapeString(fmt.Sprintf("Conversation with %s", model))
+	fmt.Fprintf(&page, "<!DOCTYPE html>\n<html lang=\"en\">\n<head>\n<meta charset=\"utf-8\">\n<title>%s</title>\n<style>%s</style>\n</head>\n<body>\n<main>\n<h1>%s</h1>\n", title, htmlExportStyle, title)
+	for _, msg := range messages {
+		fmt.Fprintf(&page, "<div class=\"message %s\">\n<div class=\"role\">%s</div>\n%s</div>\n", html.EscapeString(string(msg.Role)), roleTitles[msg.Role], renderHTMLContent(msg.Content))
+	}
+	page.WriteString("</main>\n</body>\n</html>\n")
+	return []byte(page.String())
+}
+
+// renderHTMLContent renders the content of a message, with its fenced code
+// blocks highlighted and inline code spans set apart from the text. A fence
+// that is never closed runs to the end of the content.
+func renderHTMLContent(content string) string {
+	var rendered strings.Builder
+	var text, code []string
+	language := ""
+	inFence := false
+
+	flushText := func() {
+		if joined := strings.Trim(strings.Join(text, "\n"), "\n"); joined != "" {
+			fmt.Fprintf(&rendered, "<div class=\"text\">%s</div>\n", renderInlineCode(joined))
+		}
+		text = nil
+	}
+	flushCode := func() {
+		class := ""
+		if language != "" {
+			class = fmt.Sprintf(" class=\"language-%s\"", html.EscapeString(language))
+		}
+		fmt.Fprintf(&rendered, "<pre><code%s>%s</code></pre>\n", class, highlightCode(strings.Join(code, "\n")))
+		code = nil
+	}
+
+	for _, line := range strings.Split(strings.TrimRight(content, "\n"), "\n") {
+		fence, isFence := strings.CutPrefix(strings.TrimSpace(line), "```")
+		switch {
+		case isFence && !inFence:
+			flushText()
+			language = strings.TrimSpace(fence)
+			inFence = true
+		case isFence && inFence:
+			flushCode()
+			inFence = false
+		case inFence:
+			code = append(code, line)
+		default:
+			text = append(text, line)
+		}
+	}
+	if inFence {
+		flushCode()
+	}
+	flushText()
+	return rendered.String()
+}
+
+// renderInlineCode escapes text, wrapping the spans between backticks in
+// code elements.
+func renderInlineCode(text string) string {
+	parts := strings.Split(text, "`")
+	if len(parts)%2 == 0 {
+		// An unpaired backtick is part of the text.
+		parts[len(parts)-2] += "`" + parts[len(parts)-1]
+		parts = parts[:len(parts)-1]
+	}
+
+	var rendered strings.Builder
+	for i, part := range parts {
+		if i%2 == 1 {
+			rendered.WriteString("<code>" + html.EscapeString(part) + "</code>")
+		} else {
+			rendered.WriteString(html.EscapeString(part))
+		}
+	}
+	return rendered.String()
+}
+
+// highlightCode escapes code, wrapping its comments, strings, keywords and
+// numbers in spans styled by class.
+func highlightCode(code string) string {
+	var highlighted strings.Builder
+	last := 0
+	for _, match := range codeTokens.FindAllStringSubmatchIndex(code, -1) {
+		highlighted.WriteString(html.EscapeString(code[last:match[0]]))
+		for group, name := range codeTokens.SubexpNames() {
+			if name != "" && match[2*group] >= 0 {
+				fmt.Fprintf(&highlighted, "<span class=\"hl-%s\">%s</span>", name, html.EscapeString(code[match[0]:match[1]]))
+				break
+			}
+		}
+		last = match[1]
+	}
+	highlighted.WriteString(html.EscapeString(code[last:]))
+	return highlighted.String()
+}
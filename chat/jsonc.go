@@ -0,0 +1,74 @@
+package chat
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+)
+
+// jsoncExtension is the extension of input files that may contain comments.
+const jsoncExtension = ".jsonc"
+
+// stripJSONComments blanks out the // and /* */ comments of a JSONC
+// document, leaving comment markers inside strings alone. Comments are
+// replaced with spaces, keeping their line breaks, so that the offsets in
+// JSON syntax errors still match the original file.
+func stripJSONComments(data []byte) []byte {
+	stripped := make([]byte, len(data))
+	copy(stripped, data)
+
+	inString := false
+	for i := 0; i < len(stripped); i++ {
+		switch {
+		case inString:
+			if stripped[i] == '\\' {
+				i++
+			} else if stripped[i] == '"' {
+				inString = false
+			}
+		case stripped[i] == '"':
+			inString = true
+		case stripped[i] == '/' && i+1 < len(stripped) && stripped[i+1] == '/':
+			for ; i < len(stripped) && stripped[i] != '\n'; i++ {
+				stripped[i] = ' '
+			}
+		case stripped[i] == '/' && i+1 < len(stripped) && stripped[i+1] == '*':
+			end := i + 2
+			for end < len(stripped) && !(stripped[end] == '*' && end+1 < len(stripped) && stripped[end+1] == '/') {
+				end++
+			}
+			// An unterminated comment runs to the end of the document.
+			end = min(end+2, len(stripped))
+			for ; i < end; i++ {
+				if stripped[i] != '\n' && stripped[i] != '\r' {
+					stripped[i] = ' '
+				}
+			}
+			i--
+		}
+	}
+
+	return stripped
+}
+
+// parseInputMessages decodes the messages of an input file, which is strict
+// JSON unless its extension is .jsonc, or .chat for conversation templates.
+func parseInputMessages(fileName string, data []byte) ([]MessageIn, error) {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case jsoncExtension:
+		data = stripJSONComments(data)
+	case chatTemplateExtension:
+		return parseChatTemplate(data)
+	}
+
+	var messagesIn []MessageIn
+	if err := json.Unmarshal(data, &messagesIn); err != nil {
+		return nil, err
+	}
+	return messagesIn, nil
+}
+
+func isInputFile(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	return ext == ".json" || ext == jsoncExtension || ext == chatTemplateExtension
+}
@@ -0,0 +1,31 @@
+package chat
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestStripJSONComments(t *testing.T) {
+	tests := []struct {
+		name, input, want string
+	}{
+		{"line comment", "[1, // one\n2]", "[1,       \n2]"},
+		{"block comment", "[1, /* one */ 2]", "[1,           2]"},
+		{"line comment marker in string", `{"url": "http://x"}`, `{"url": "http://x"}`},
+		{"block comment marker in string", `{"a": "/* b */"}`, `{"a": "/* b */"}`},
+		{"escaped quotes", `{"a": "say \"// hi\""} // c`, `{"a": "say \"// hi\""}     `},
+		{"escaped backslash", `{"a": "\\"} // c`, `{"a": "\\"}     `},
+		{"unterminated block comment", "[1] /* open", "[1]        "},
+		{"CRLF line comment", "[1, // one\r\n2]", "[1,        \n2]"},
+		{"CRLF block comment", "[1, /* a\r\nb */ 2]", "[1,     \r\n     2]"},
+	}
+	for _, test := range tests {
+		got := string(stripJSONComments([]byte(test.input)))
+		if got != test.want {
+			t.Errorf("%s: stripJSONComments(%q) = %q, want %q", test.name, test.input, got, test.want)
+		}
+		if !json.Valid([]byte(got)) {
+			t.Errorf("%s: stripJSONComments(%q) = %q, which is not valid JSON", test.name, test.input, got)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package chat
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	jsonLogFormat = "json"
+	yamlLogFormat = "yaml"
+)
+
+// logFileSuffix returns the suffix of the conversation log files saved in
+// format.
+func logFileSuffix(format string) string {
+	if format == yamlLogFormat {
+		return ".log.yaml"
+	}
+	return ".log.json"
+}
+
+func marshalConversation(messages []Message, format string) ([]byte, error) {
+	if format != yamlLogFormat {
+		return json.MarshalIndent(messages, "", "  ")
+	}
+
+	document := &yaml.Node{Kind: yaml.SequenceNode}
+	for _, msg := range messages {
+		document.Content = append(document.Content, yamlMessageNode(msg))
+	}
+	return yaml.Marshal(document)
+}
+
+// yamlMessageNode writes multiline content as a literal block, so logs are
+// easy to read and edit. yaml.v3 writes some text, e.g. with tabs or leading
+// blank lines, as blocks that it can't read back, so each message is checked
+// and such content is written as a quoted string instead.
+func yamlMessageNode(msg Message) *yaml.Node {
+	content := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: msg.Content}
+	if strings.Contains(msg.Content, "\n") {
+		content.Style = yaml.LiteralStyle
+	}
+	node := &yaml.Node{
+		Kind: yaml.MappingNode,
+		Content: []*yaml.Node{
+			{Kind: yaml.ScalarNode, Value: "role"},
+			{Kind: yaml.ScalarNode, Tag: "!!str", Value: string(msg.Role)},
+			{Kind: yaml.ScalarNode, Value: "content"},
+			content,
+		},
+	}
+	if msg.Cache {
+		node.Content = append(node.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Value: "cache"},
+			&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: "true"},
+		)
+	}
+
+	var decoded []Message
+	data, err := yaml.Marshal(&yaml.Node{Kind: yaml.SequenceNode, Content: []*yaml.Node{node}})
+	if err != nil || yaml.Unmarshal(data, &decoded) != nil || len(decoded) != 1 || decoded[0] != msg {
+		content.Style = yaml.DoubleQuotedStyle
+	}
+	return node
+}
+
+// unmarshalConversation decodes a conversation log in the format given by
+// the extension of its file name, which is JSON unless it is .yaml or .yml.
+func unmarshalConversation(fileName string, data []byte, messages *[]Message) error {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".yaml", ".yml":
+		return yaml.Unmarshal(data, messages)
+	default:
+		return json.Unmarshal(data, messages)
+	}
+}
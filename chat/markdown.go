@@ -0,0 +1,179 @@
+package chat
+
+import (
+	"io"
+	"strings"
+)
+
+// Values of --render, which sets how responses are displayed.
+const (
+	rawRender      = "raw"
+	markdownRender = "markdown"
+)
+
+// rendersMarkdown reports whether responses are displayed with their
+// Markdown rendered on out. Output without ANSI styles, such as a redirected
+// one, stays raw.
+func rendersMarkdown(out io.Writer, cfg *Config) bool {
+	output, ok := out.(*sessionOutput)
+	return cfg.Render == markdownRender && ok && output.color
+}
+
+// renderMarkdown returns content with its Markdown rendered for the terminal.
+func renderMarkdown(content string) string {
+	var renderer markdownRenderer
+	return renderer.write(content) + renderer.flush()
+}
+
+// markdownRenderer styles Markdown for the terminal as it arrives, in chunks
+// that can split its syntax anywhere, so streamed responses are rendered
+// progressively. Headings, list items, quotes and code fences are recognized
+// at the start of each line, holding back only the few characters needed to
+// tell them apart from text, and bold and code spans are styled from their
+// opening marker on. Code blocks are shown verbatim. Spans never extend past
+// the end of their line, so an unclosed marker only affects one line.
+type markdownRenderer struct {
+	// pending holds the characters that can't be rendered until the next
+	// chunk tells what they are, like the start of a line or a single *.
+	pending   string
+	midLine   bool
+	inFence   bool
+	fenceLine bool
+	heading   bool
+	quote     bool
+	bold      bool
+	code      bool
+}
+
+// write renders the next chunk of the content. The returned text can be
+// shorter than the chunk, with the rest held back until the next call.
+func (r *markdownRenderer) write(chunk string) string {
+	var out strings.Builder
+	text := r.pending + chunk
+	r.pending = ""
+
+	for i := 0; i < len(text); {
+		if !r.midLine {
+			n, ok := r.startLine(&out, text[i:])
+			if !ok {
+				r.pending = text[i:]
+				break
+			}
+			i += n
+			r.midLine = true
+			continue
+		}
+
+		switch c := text[i]; {
+		case c == '\n':
+			r.endLine(&out)
+			i++
+		case r.inFence || r.fenceLine:
+			out.WriteByte(c)
+			i++
+		case c == '`':
+			r.code = !r.code
+			out.WriteString(r.style())
+			i++
+		case c == '*' && !r.code && i+1 == len(text):
+			r.pending = text[i:]
+			i++
+		case c == '*' && !r.code && text[i+1] == '*':
+			r.bold = !r.bold
+			out.WriteString(r.style())
+			i += 2
+		default:
+			out.WriteByte(c)
+			i++
+		}
+	}
+	return out.String()
+}
+
+// flush returns the characters held back once the content is complete, and
+// resets the styles.
+func (r *markdownRenderer) flush() string {
+	text := r.pending
+	r.pending = ""
+	if r.style() != ansiReset {
+		text += ansiReset
+	}
+	return text
+}
+
+// startLine renders the block syntax at the start of line, returning how
+// many of its characters were consumed. It reports false when more
+// characters are needed to tell whether the line starts with a marker.
+func (r *markdownRenderer) startLine(out *strings.Builder, line string) (int, bool) {
+	indent := len(line) - len(strings.TrimLeft(line, " "))
+	body := line[indent:]
+	if body == "" || (len(body) < 3 && strings.HasPrefix("```", body)) {
+		return 0, false
+	}
+
+	if strings.HasPrefix(body, "```") {
+		r.fenceLine = true
+		out.WriteString(r.style() + line[:indent+3])
+		return indent + 3, true
+	}
+	if r.inFence {
+		out.WriteString(r.style())
+		return 0, true
+	}
+
+	switch body[0] {
+	case '#':
+		level := len(body) - len(strings.TrimLeft(body, "#"))
+		if level == len(body) {
+			return 0, false
+		}
+		if body[level] == ' ' {
+			r.heading = true
+			out.WriteString(r.style())
+			return indent + level + 1, true
+		}
+	case '-', '*', '+':
+		if len(body) == 1 {
+			return 0, false
+		}
+		if body[1] == ' ' {
+			out.WriteString(line[:indent] + "• ")
+			return indent + 2, true
+		}
+	case '>':
+		r.quote = true
+		out.WriteString(line[:indent] + r.style() + "│")
+		return indent + 1, true
+	}
+	return 0, true
+}
+
+// endLine ends the styles of the current line, toggling code blocks on their
+// fence lines.
+func (r *markdownRenderer) endLine(out *strings.Builder) {
+	if r.style() != ansiReset {
+		out.WriteString(ansiReset)
+	}
+	out.WriteString("\n")
+
+	if r.fenceLine {
+		r.inFence = !r.inFence
+	}
+	r.fenceLine, r.heading, r.quote, r.bold, r.code = false, false, false, false, false
+	r.midLine = false
+}
+
+// style returns the ANSI sequence that switches to the styles in effect.
+func (r *markdownRenderer) style() string {
+	style := ansiReset
+	if r.heading || r.bold {
+		style += ansiBold
+	}
+	if r.quote || r.fenceLine {
+		style += ansiDim
+	}
+	if r.code || r.inFence {
+		style += ansiCyan
+	}
+	return style
+}
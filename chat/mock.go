@@ -0,0 +1,123 @@
+package chat
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	openAIProvider = "openai"
+	mockProvider   = "mock"
+
+	mockModel = "mock"
+	mockURL   = "http://mock.invalid/v1/chat/completions"
+)
+
+// mockTransport answers chat requests locally, as an OpenAI-compatible
+// provider would, so that the whole session can run without network access.
+// It replies with the canned response, when set, or echoes the last message.
+// Token usage is the number of words, to exercise the usage display. A canned
+// response that is a chat completion object, with a choices field, is sent
+// as it is instead, to reproduce the replies of a provider, such as ones
+// without content.
+type mockTransport struct {
+	response string
+}
+
+func (t *mockTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method == http.MethodGet {
+		return mockResponse(req, "application/json", `{"data": [{"id": "`+mockModel+`"}]}`), nil
+	}
+
+	var payload RequestPayload
+	if err := json.NewDecoder(req.Body).Decode(&payload); err != nil {
+		return nil, fmt.Errorf("invalid mock request: %w", err)
+	}
+	req.Body.Close()
+
+	if completion, ok := mockCompletion(t.response); ok {
+		if payload.Stream {
+			choice := completion.Choices[0]
+			return mockResponse(req, "text/event-stream", mockStream(choice.Message.Content, choice.FinishReason, payload, completion.Usage)), nil
+		}
+		return mockResponse(req, "application/json", t.response), nil
+	}
+
+	content := t.response
+	if content == "" && len(payload.Messages) > 0 {
+		content = "echo: " + payload.Messages[len(payload.Messages)-1].Content
+	}
+
+	// Like a real model, the reply is cut off at max_tokens, or
+	// max_completion_tokens, where a token is a word.
+	finishReason := "stop"
+	maxTokens := max(payload.MaxTokens, payload.MaxCompletionTokens)
+	if words := strings.SplitAfter(content, " "); maxTokens > 0 && len(words) > maxTokens {
+		content = strings.TrimRight(strings.Join(words[:maxTokens], ""), " ")
+		finishReason = finishReasonLength
+	}
+
+	usage := &Usage{CompletionTokens: len(strings.Fields(content))}
+	for _, msg := range payload.Messages {
+		usage.PromptTokens += len(strings.Fields(msg.Content))
+	}
+
+	if payload.Stream {
+		return mockResponse(req, "text/event-stream", mockStream(content, finishReason, payload, usage)), nil
+	}
+
+	body := ResponseBody{Usage: usage}
+	for range max(payload.N, 1) {
+		body.Choices = append(body.Choices, ResponseChoice{
+			Message:      Message{Role: ASSISTANT, Content: content},
+			FinishReason: finishReason,
+		})
+	}
+	bodyBytes, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling mock response: %w", err)
+	}
+	return mockResponse(req, "application/json", string(bodyBytes)), nil
+}
+
+// mockCompletion decodes response if it is a chat completion object with at
+// least one choice.
+func mockCompletion(response string) (ResponseBody, bool) {
+	var completion ResponseBody
+	if !strings.HasPrefix(strings.TrimSpace(response), "{") || json.Unmarshal([]byte(response), &completion) != nil {
+		return ResponseBody{}, false
+	}
+	return completion, len(completion.Choices) > 0
+}
+
+// mockStream streams content one word at a time, as server-sent events.
+func mockStream(content string, finishReason string, payload RequestPayload, usage *Usage) string {
+	var events strings.Builder
+	writeChunk := func(chunk StreamChunk) {
+		data, _ := json.Marshal(chunk)
+		fmt.Fprintf(&events, "data: %s\n\n", data)
+	}
+
+	for _, word := range strings.SplitAfter(content, " ") {
+		writeChunk(StreamChunk{Choices: []StreamChoice{{Delta: StreamDelta{Content: word}}}})
+	}
+	writeChunk(StreamChunk{Choices: []StreamChoice{{FinishReason: finishReason}}})
+	if payload.StreamOptions != nil && payload.StreamOptions.IncludeUsage {
+		writeChunk(StreamChunk{Choices: []StreamChoice{}, Usage: usage})
+	}
+	events.WriteString("data: [DONE]\n\n")
+
+	return events.String()
+}
+
+func mockResponse(req *http.Request, contentType string, body string) *http.Response {
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {contentType}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}
+}
@@ -0,0 +1,87 @@
+package chat
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+)
+
+// ModelDefaults are the default request parameters of the models whose name
+// matches the Model pattern, in the syntax of path.Match, e.g. "gpt-4o*".
+type ModelDefaults struct {
+	Model string `json:"model"`
+	// Temperature is the default temperature, or null for models that
+	// reject it, so that it isn't sent at all.
+	Temperature json.RawMessage `json:"temperature,omitempty"`
+	Seed        *int            `json:"seed,omitempty"`
+	N           *int            `json:"n,omitempty"`
+}
+
+func readModelDefaults(fileName string) ([]ModelDefaults, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read model defaults file: %w", err)
+	}
+
+	var defaults []ModelDefaults
+	if err := json.Unmarshal(data, &defaults); err != nil {
+		return nil, fmt.Errorf("invalid model defaults file %s: %w", fileName, err)
+	}
+	for i, entry := range defaults {
+		if _, err := path.Match(entry.Model, ""); err != nil || entry.Model == "" {
+			return nil, fmt.Errorf("invalid model defaults file %s: entry %d: invalid model pattern \"%s\"", fileName, i, entry.Model)
+		}
+	}
+
+	return defaults, nil
+}
+
+// applyModelDefaults sets the parameters of the first entry of
+// cfg.ModelDefaults that matches cfg.Model, except those given explicitly,
+// as flags or restored from a conversation log. It is applied once the model
+// is known, which may be after picking it on startup. It returns the names of
+// the parameters it set.
+func applyModelDefaults(out io.Writer, cfg *Config) ([]string, error) {
+	var applied []string
+	for _, entry := range cfg.ModelDefaults {
+		if matched, _ := path.Match(entry.Model, cfg.Model); !matched {
+			continue
+		}
+
+		if len(entry.Temperature) > 0 && !cfg.fixedParams["temperature"] {
+			if string(entry.Temperature) == "null" {
+				cfg.OmitTemperature = true
+			} else {
+				var temperature float64
+				if err := json.Unmarshal(entry.Temperature, &temperature); err != nil {
+					return nil, fmt.Errorf("invalid default temperature for %s: %w", entry.Model, err)
+				}
+				validTemperature, err := validateTemperature(out, temperature, false)
+				if err != nil {
+					return nil, err
+				}
+				cfg.Temperature = validTemperature
+			}
+			applied = append(applied, "temperature")
+		}
+		if entry.Seed != nil && !cfg.fixedParams["seed"] {
+			cfg.Seed = entry.Seed
+			applied = append(applied, "seed")
+		}
+		if entry.N != nil && !cfg.fixedParams["n"] {
+			if *entry.N < 1 {
+				return nil, fmt.Errorf("invalid default n for %s: must be at least 1", entry.Model)
+			}
+			if err := validateChoices(*entry.N, cfg.Stream); err != nil {
+				return nil, fmt.Errorf("invalid default n for %s: %w", entry.Model, err)
+			}
+			cfg.Choices = *entry.N
+			applied = append(applied, "n")
+		}
+		return applied, nil
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,139 @@
+package chat
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type ModelsResponse struct {
+	Data []struct {
+		ID string `json:"id"`
+	} `json:"data"`
+}
+
+// modelsURL derives the URL of the OpenAI-compatible models endpoint from the
+// chat completion URL, e.g. ".../v1/chat/completions" becomes ".../v1/models".
+func modelsURL(chatURL string) (string, error) {
+	baseURL, found := strings.CutSuffix(strings.TrimRight(chatURL, "/"), "/chat/completions")
+	if !found {
+		return "", fmt.Errorf("can't derive the models URL from %s", chatURL)
+	}
+	return baseURL + "/models", nil
+}
+
+func listModels(client *http.Client, cfg *Config) ([]string, error) {
+	url, err := modelsURL(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+	setAuthorization(req, cfg)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error listing models: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading models list: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("listing models failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var modelsResponse ModelsResponse
+	if err := json.Unmarshal(body, &modelsResponse); err != nil {
+		return nil, fmt.Errorf("error unmarshalling models list: %w", err)
+	}
+
+	models := make([]string, 0, len(modelsResponse.Data))
+	for _, model := range modelsResponse.Data {
+		models = append(models, model.ID)
+	}
+	sort.Strings(models)
+
+	return models, nil
+}
+
+// readModelAllowlist reads the models users may select, one per line.
+// Blank lines and lines starting with # are ignored.
+func readModelAllowlist(fileName string) ([]string, error) {
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read model allowlist: %w", err)
+	}
+
+	var allowlist []string
+	for _, line := range strings.Split(string(content), "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			allowlist = append(allowlist, line)
+		}
+	}
+	if len(allowlist) == 0 {
+		return nil, fmt.Errorf("model allowlist %s lists no models", fileName)
+	}
+	return allowlist, nil
+}
+
+// checkModelAllowed fails when model isn't in allowlist, naming the allowed
+// ones. Every model is allowed when the allowlist is empty.
+func checkModelAllowed(allowlist []string, model string) error {
+	if len(allowlist) == 0 || slices.Contains(allowlist, model) {
+		return nil
+	}
+	return fmt.Errorf("model %q is not in the allowlist. Allowed models: %s", model, strings.Join(allowlist, ", "))
+}
+
+// pickModel lists the models available from the provider, within the model
+// allowlist if any, and asks the user to choose one of them.
+func pickModel(out io.Writer, client *http.Client, cfg *Config, reader *bufio.Reader) (string, error) {
+	models, err := listModels(client, cfg)
+	if err != nil {
+		return "", err
+	}
+	if len(models) == 0 {
+		return "", fmt.Errorf("the provider returned no models")
+	}
+	if len(cfg.ModelAllowlist) > 0 {
+		models = slices.DeleteFunc(models, func(model string) bool { return !slices.Contains(cfg.ModelAllowlist, model) })
+		if len(models) == 0 {
+			return "", fmt.Errorf("none of the provider's models are in the allowlist. Allowed models: %s", strings.Join(cfg.ModelAllowlist, ", "))
+		}
+	}
+
+	fmt.Fprintln(out, "No model was configured. Available models:")
+	fmt.Fprintln(out)
+	for i, model := range models {
+		fmt.Fprintf(out, "  %3d) %s\n", i+1, model)
+	}
+	fmt.Fprintln(out)
+
+	for {
+		choice, err := readUserInput(out, reader, "Pick a model number: ")
+		if err != nil {
+			return "", err
+		}
+
+		n, err := strconv.Atoi(strings.TrimSpace(choice))
+		if err != nil || n < 1 || n > len(models) {
+			fmt.Fprintf(out, "!! Enter a number between 1 and %d\n", len(models))
+			continue
+		}
+
+		return models[n-1], nil
+	}
+}
@@ -0,0 +1,47 @@
+package chat
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// LogNote is an annotation saved with a conversation log, which is never
+// sent to the model.
+type LogNote struct {
+	// After is the number of messages that preceded the note.
+	After int       `json:"after"`
+	Text  string    `json:"text"`
+	Time  time.Time `json:"time"`
+}
+
+// addNote records text as a note after the current messages.
+func addNote(annotations *logAnnotations, messages []Message, text string) {
+	annotations.notes = append(annotations.notes, LogNote{After: len(messages), Text: text, Time: time.Now().UTC()})
+}
+
+// printNotes lists the notes of the session, each with the number of
+// messages it follows.
+func printNotes(out io.Writer, notes []LogNote) {
+	if len(notes) == 0 {
+		fmt.Fprintln(out, "No notes. Use /note <text> to add one")
+		return
+	}
+	for _, note := range notes {
+		fmt.Fprintf(out, "[after message %d] %s\n", note.After, note.Text)
+	}
+}
+
+// shiftNotes returns the notes of a conversation log once the messages at the
+// removed indices are dropped from it, keeping only those within its count
+// remaining messages.
+func shiftNotes(logNotes []LogNote, removed []int, count int) []LogNote {
+	var notes []LogNote
+	for _, note := range logNotes {
+		note.After = shiftIndex(note.After, removed)
+		if note.After <= count {
+			notes = append(notes, note)
+		}
+	}
+	return notes
+}
@@ -0,0 +1,120 @@
+package chat
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// flagEnvVars are the environment variables the flags default to.
+var flagEnvVars = map[string]string{
+	"api-key":         "LLM_PROVIDER_KEY",
+	"model":           "LLM_MODEL",
+	"model-allowlist": "LLM_MODEL_ALLOWLIST",
+	"url":             "CHAT_COMPLETION_URL",
+	"temperature":     "TEMPERATURE",
+	"input-dir":       "INPUT_DIR",
+	"prompts-dir":     "PROMPTS_DIR",
+	"logs-dir":        "LOGS_DIR",
+	"end-user":        "LLM_END_USER",
+}
+
+// redactedSecret replaces the values of the secret flags in the profile.
+const redactedSecret = "REDACTED"
+
+// secretFlags are redacted from the profile.
+var secretFlags = map[string]bool{
+	"api-key":    true,
+	"basic-auth": true,
+}
+
+// configSetting is the resolved value of a flag and where it came from.
+type configSetting struct {
+	name   string
+	value  string
+	source string
+}
+
+// environNames returns the names of the variables set in the environment.
+func environNames() map[string]bool {
+	names := map[string]bool{}
+	for _, variable := range os.Environ() {
+		name, _, _ := strings.Cut(variable, "=")
+		names[name] = true
+	}
+	return names
+}
+
+// describeSettings lists the resolved value of every flag along with its
+// source: given as a flag, restored from a conversation log, printed by the
+// API key command, read from the environment, which processEnv lists, or
+// from the env file, or the default. The settings that aren't held by their
+// flag take their value from values.
+func describeSettings(flags *flag.FlagSet, values map[string]string, explicit, restored, processEnv map[string]bool, apiKeyFromCmd bool) []configSetting {
+	var settings []configSetting
+	flags.VisitAll(func(f *flag.Flag) {
+		setting := configSetting{name: f.Name, value: f.Value.String(), source: "default"}
+		if value, ok := values[f.Name]; ok {
+			setting.value = value
+		}
+		envVar, hasEnvVar := flagEnvVars[f.Name]
+
+		switch {
+		case explicit[f.Name]:
+			setting.source = "flag"
+		case restored[f.Name]:
+			setting.source = "log"
+		case f.Name == "api-key" && apiKeyFromCmd:
+			setting.source = "--api-key-cmd"
+		case hasEnvVar && os.Getenv(envVar) != "" && processEnv[envVar]:
+			setting.source = "env " + envVar
+		case hasEnvVar && os.Getenv(envVar) != "":
+			setting.source = "env file " + envVar
+		}
+
+		if secretFlags[f.Name] && setting.value != "" {
+			setting.value = redactedSecret
+		}
+		settings = append(settings, setting)
+	})
+	return settings
+}
+
+// resolvedValues returns the values of the settings that aren't held by
+// their flag: the seed, which is restored from a log without setting the
+// flag, and isn't sent at all when unset.
+func resolvedValues(seed *int) map[string]string {
+	values := map[string]string{"seed": ""}
+	if seed != nil {
+		values["seed"] = fmt.Sprint(*seed)
+	}
+	return values
+}
+
+// printProfile prints the effective configuration, once the model defaults
+// are applied, whose parameters are shown as they are sent.
+func printProfile(out io.Writer, cfg *Config, fromModelDefaults []string) {
+	modelDefaults := map[string]string{}
+	for _, name := range fromModelDefaults {
+		switch name {
+		case "temperature":
+			modelDefaults[name] = fmt.Sprint(cfg.Temperature)
+			if cfg.OmitTemperature {
+				modelDefaults[name] = "omitted"
+			}
+		case "seed":
+			modelDefaults[name] = fmt.Sprint(*cfg.Seed)
+		case "n":
+			modelDefaults[name] = fmt.Sprint(cfg.Choices)
+		}
+	}
+
+	for _, setting := range cfg.settings {
+		if value, ok := modelDefaults[setting.name]; ok {
+			setting.value, setting.source = value, "model defaults"
+		}
+		fmt.Fprintf(out, "--%-20s %-40s (%s)\n", setting.name, fmt.Sprintf("%q", setting.value), setting.source)
+	}
+}
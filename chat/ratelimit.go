@@ -0,0 +1,60 @@
+package chat
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket that holds a single token, refilled at the
+// configured number of requests per minute, so requests are spaced evenly
+// instead of being sent in bursts.
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+func newRateLimiter(requestsPerMinute int) *rateLimiter {
+	return &rateLimiter{interval: time.Minute / time.Duration(requestsPerMinute)}
+}
+
+// reserve takes the next token and returns how long to wait before it is
+// available.
+func (l *rateLimiter) reserve() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	return delay
+}
+
+// rateLimitedTransport waits for the limiter before sending each request.
+type rateLimitedTransport struct {
+	limiter *rateLimiter
+	next    http.RoundTripper
+	// out is told when requests are throttled.
+	out io.Writer
+}
+
+func (t *rateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if delay := t.limiter.reserve(); delay > 0 {
+		warnf(t.out, "Throttling: waiting %.1fs to stay within --rpm", delay.Seconds())
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+	}
+
+	return t.next.RoundTrip(req)
+}
@@ -0,0 +1,78 @@
+package chat
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// replayConversationLog re-sends each user turn of a conversation log to the
+// current model, with the logged history up to that turn, and compares the
+// new reply to the logged one.
+func replayConversationLog(out io.Writer, client *http.Client, cfg *Config, fileName string) error {
+	messages, err := readConversationLog(fileName)
+	if err != nil {
+		return err
+	}
+
+	turns, divergences, failures := 0, 0, 0
+	for i, msg := range messages {
+		if msg.Role != USER {
+			continue
+		}
+		turns++
+
+		fmt.Fprintln(out, styled(out, ansiBold, fmt.Sprintf("[%d] %s", i, truncateLine(msg.Content, 60))))
+
+		payload := RequestPayload{
+			Model:       cfg.Model,
+			Messages:    messages[:i+1],
+			Temperature: float32(cfg.Temperature),
+		}
+		assistantMessage, _, err := completeConversation(out, client, cfg, payload)
+		if err != nil {
+			failures++
+			fmt.Fprintf(out, "!! Error: %v\n\n", err)
+			emitEvent(out, "error", map[string]any{"message": err.Error(), "turn": i})
+			continue
+		}
+		emitEvent(out, "response", map[string]any{"message": assistantMessage, "turn": i})
+
+		if i+1 >= len(messages) || messages[i+1].Role != ASSISTANT {
+			divergences++
+			fmt.Fprintln(out, "no logged answer")
+			printDiffLines(out, "+ ", ansiGreen, assistantMessage.Content)
+		} else if messages[i+1].Content == assistantMessage.Content {
+			fmt.Fprintln(out, "same answer")
+		} else {
+			divergences++
+			fmt.Fprintln(out, "answer differs")
+			printContentDiff(out, messages[i+1].Content, assistantMessage.Content)
+		}
+		fmt.Fprintln(out)
+	}
+
+	fmt.Fprintf(out, "%d of %d turns diverged", divergences, turns)
+	if failures > 0 {
+		fmt.Fprintf(out, ", %d failed", failures)
+	}
+	fmt.Fprintln(out)
+
+	if failures > 0 {
+		return fmt.Errorf("%d of %d requests failed", failures, turns)
+	}
+	return nil
+}
+
+// truncateLine returns the first line of text, shortened to width.
+func truncateLine(text string, width int) string {
+	line, _, multiline := strings.Cut(text, "\n")
+	if len([]rune(line)) > width {
+		return string([]rune(line)[:width-3]) + "..."
+	}
+	if multiline {
+		return line + "..."
+	}
+	return line
+}
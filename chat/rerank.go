@@ -0,0 +1,66 @@
+package chat
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const rerankInstructions = `You are judging candidate answers to the last user message of a conversation.
+Pick the candidate that best answers it, considering correctness, helpfulness and clarity.
+Reply only with a JSON object of the form {"best": <candidate number>, "reason": "<one sentence>"}.`
+
+type rerankVerdict struct {
+	Best   int    `json:"best"`
+	Reason string `json:"reason"`
+}
+
+// rerankChoices asks the model which of the choices best answers the
+// conversation, returning the index of the winner and the model's reasoning.
+func rerankChoices(out io.Writer, client *http.Client, cfg *Config, messages []Message, choices []ResponseChoice) (int, string, error) {
+	var prompt strings.Builder
+	prompt.WriteString("# Conversation\n\n")
+	for _, msg := range messages {
+		if msg.Role != SYSTEM {
+			fmt.Fprintf(&prompt, "## %s\n\n%s\n\n", msg.Role, msg.Content)
+		}
+	}
+	prompt.WriteString("# Candidates\n\n")
+	for i, choice := range choices {
+		fmt.Fprintf(&prompt, "## Candidate %d\n\n%s\n\n", i+1, choice.Message.Content)
+	}
+
+	payload := RequestPayload{
+		Model: cfg.Model,
+		Messages: []Message{
+			{Role: SYSTEM, Content: rerankInstructions},
+			{Role: USER, Content: prompt.String()},
+		},
+	}
+
+	var responseBody ResponseBody
+	if _, err := sendChatRequest(out, client, cfg, payload, &responseBody); err != nil {
+		return 0, "", err
+	}
+	if len(responseBody.Choices) == 0 {
+		return 0, "", fmt.Errorf("no response from API")
+	}
+
+	content := responseBody.Choices[0].Message.Content
+	start, end := strings.Index(content, "{"), strings.LastIndex(content, "}")
+	if start < 0 || end < start {
+		return 0, "", fmt.Errorf("unexpected verdict: %s", content)
+	}
+
+	var verdict rerankVerdict
+	if err := json.Unmarshal([]byte(content[start:end+1]), &verdict); err != nil {
+		return 0, "", fmt.Errorf("failed to parse verdict: %w", err)
+	}
+	if verdict.Best < 1 || verdict.Best > len(choices) {
+		return 0, "", fmt.Errorf("verdict picked candidate %d out of %d", verdict.Best, len(choices))
+	}
+
+	return verdict.Best - 1, verdict.Reason, nil
+}
@@ -0,0 +1,72 @@
+package chat
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// applyResendOverrides parses the arguments of /resend, such as
+// "--temp 1.0 --seed 7", and applies them to cfg for the rest of the session.
+// It returns a description of each parameter that changed. Nothing is applied
+// unless all the overrides are valid.
+func applyResendOverrides(cfg *Config, args string) ([]string, error) {
+	flags := flag.NewFlagSet("/resend", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	temperature := flags.Float64("temp", cfg.Temperature, "")
+	seed := flags.Int("seed", 0, "")
+	maxTokens := flags.Int("max-tokens", 0, "")
+	if err := flags.Parse(strings.Fields(args)); err != nil {
+		return nil, fmt.Errorf("%v. Usage: /resend [--temp <value>] [--seed <value>] [--max-tokens <value>]", err)
+	}
+	if flags.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected argument %q", flags.Arg(0))
+	}
+
+	set := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	if _, err := validateTemperature(io.Discard, *temperature, true); err != nil {
+		return nil, err
+	}
+	if *maxTokens < 0 {
+		return nil, fmt.Errorf("invalid --max-tokens value %d: must not be negative", *maxTokens)
+	}
+
+	var changes []string
+	if set["temp"] && (*temperature != cfg.Temperature || cfg.OmitTemperature) {
+		changes = append(changes, fmt.Sprintf("temperature %v -> %v", cfg.Temperature, *temperature))
+		cfg.Temperature = *temperature
+		cfg.OmitTemperature = false
+	}
+	if set["seed"] && (cfg.Seed == nil || *cfg.Seed != *seed) {
+		previous := "none"
+		if cfg.Seed != nil {
+			previous = fmt.Sprint(*cfg.Seed)
+		}
+		changes = append(changes, fmt.Sprintf("seed %s -> %d", previous, *seed))
+		cfg.Seed = seed
+	}
+	// The limit replaces the one in effect, whichever field it's sent as.
+	limit := &cfg.MaxTokens
+	if cfg.MaxCompletionTokens > 0 {
+		limit = &cfg.MaxCompletionTokens
+	}
+	if set["max-tokens"] && *maxTokens != *limit {
+		changes = append(changes, fmt.Sprintf("max tokens %d -> %d", *limit, *maxTokens))
+		*limit = *maxTokens
+	}
+	return changes, nil
+}
+
+// dropLastReply removes the messages after the last user message, so it can
+// be sent again. It reports false if there is no user message.
+func dropLastReply(messages []Message) ([]Message, bool) {
+	for last := len(messages) - 1; last >= 0; last-- {
+		if messages[last].Role == USER {
+			return messages[:last+1], true
+		}
+	}
+	return messages, false
+}
@@ -0,0 +1,485 @@
+package chat
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// Session is an interactive chat with an LLM provider, set up from a Config.
+// It reads the user input from in and writes the conversation to out.
+type Session struct {
+	cfg *Config
+	in  *bufio.Reader
+	out *sessionOutput
+	// terminal is whether in is a terminal, which the session can ask
+	// for confirmations on.
+	terminal bool
+}
+
+// logAnnotations are what a session adds to the metadata of its conversation
+// log, apart from the Config, which sessions can share.
+type logAnnotations struct {
+	// notes are the annotations added during the session, saved in the log
+	// metadata rather than sent.
+	notes []LogNote
+	// requests are the IDs of the requests sent with --trace for each
+	// assistant message.
+	requests []LogRequests
+}
+
+// sessionOutput is where a session writes: its human-readable output, with
+// whether it is styled, its warnings and its --jsonl-events events. Helpers
+// take it as their io.Writer, keeping the state of each session apart.
+type sessionOutput struct {
+	io.Writer
+	color    bool
+	warnings *log.Logger
+	events   *eventWriter
+}
+
+// SessionOption configures a Session created by NewSession.
+type SessionOption func(*sessionOptions)
+
+type sessionOptions struct {
+	errOut io.Writer
+}
+
+// WithErrorOutput writes the warnings and errors of the session to w, instead
+// of its output. With --jsonl-events, the events take over the output, and
+// the rest of the human-readable text goes to w as well, or is discarded
+// without this option.
+func WithErrorOutput(w io.Writer) SessionOption {
+	return func(options *sessionOptions) {
+		options.errOut = w
+	}
+}
+
+// NewSession returns a session that reads the user input from in and writes
+// its output to out.
+func NewSession(cfg *Config, in io.Reader, out io.Writer, options ...SessionOption) *Session {
+	var opts sessionOptions
+	for _, option := range options {
+		option(&opts)
+	}
+
+	output := &sessionOutput{Writer: out}
+	errOut := opts.errOut
+	if cfg.JSONLEvents {
+		// Events are written to out so they can be consumed
+		// programmatically, away from the human-readable output.
+		output.events = &eventWriter{w: out}
+		if errOut == nil {
+			errOut = io.Discard
+		}
+		output.Writer = errOut
+	} else if errOut == nil {
+		errOut = out
+	}
+	output.color = useColor(output.Writer)
+	output.warnings = log.New(errOut, "", log.LstdFlags)
+
+	file, ok := in.(*os.File)
+	return &Session{cfg: cfg, in: bufio.NewReader(in), out: output, terminal: ok && isTerminal(file)}
+}
+
+// warnf writes a warning to the warnings of the session that out is the
+// output of, or to out itself otherwise.
+func warnf(out io.Writer, format string, args ...any) {
+	if output, ok := out.(*sessionOutput); ok {
+		output.warnings.Printf(format, args...)
+		return
+	}
+	fmt.Fprintln(out, fmt.Sprintf(format, args...))
+}
+
+// Run runs the session until the user quits or the input ends. Depending on
+// the Config, it compares, replays, counts or sends conversations without
+// prompting instead, and returns once done.
+func (s *Session) Run() error {
+	cfg := s.cfg
+	for _, warning := range cfg.warnings {
+		warnf(s.out, "%s", warning)
+	}
+
+	if cfg.ProfileDump {
+		fromModelDefaults, err := applyModelDefaults(s.out, cfg)
+		if err != nil {
+			return err
+		}
+		printProfile(s.out, cfg, fromModelDefaults)
+		return nil
+	}
+
+	if len(cfg.DiffFiles) > 0 {
+		if err := diffConversationLogs(s.out, cfg.DiffFiles[0], cfg.DiffFiles[1]); err != nil {
+			return fmt.Errorf("failed to compare conversation logs: %w", err)
+		}
+		return nil
+	}
+
+	reader, terminal := s.in, s.terminal
+	client, err := newHTTPClient(s.out, cfg)
+	if err != nil {
+		return fmt.Errorf("failed to configure HTTP client: %w", err)
+	}
+
+	if cfg.Model == "" && !cfg.CountOnly {
+		// The model can only be picked by someone at a terminal.
+		if !terminal {
+			return fmt.Errorf("missing LLM model. Use --model flag or LLM_MODEL env var")
+		}
+		model, err := pickModel(s.out, client, cfg, reader)
+		if err != nil {
+			return fmt.Errorf("missing LLM model. Use --model flag or LLM_MODEL env var: %w", err)
+		}
+		cfg.Model = model
+	}
+	if _, err := applyModelDefaults(s.out, cfg); err != nil {
+		return err
+	}
+
+	if cfg.ReplayFile != "" {
+		if err := replayConversationLog(s.out, client, cfg, cfg.ReplayFile); err != nil {
+			return fmt.Errorf("replay failed: %w", err)
+		}
+		return nil
+	}
+
+	if cfg.BatchDir != "" {
+		if err := runBatch(s.out, client, cfg); err != nil {
+			return fmt.Errorf("batch failed: %w", err)
+		}
+		return nil
+	}
+
+	var messages []Message
+	var sourceFiles [][]string
+	annotations := &logAnnotations{}
+	resumeFile := cfg.ResumeFile
+	if cfg.Pick {
+		resumeFile, err = pickConversationLog(s.out, reader, cfg.LogsDir, cfg.TagFilter)
+		if err != nil {
+			return err
+		}
+	}
+	if resumeFile != "" {
+		messages, err = readResumedLog(s.out, annotations, resumeFile)
+		if err != nil {
+			return err
+		}
+		if cfg.Pick {
+			// Resumed logs keep their tags, which --resume restores along
+			// with the rest of the metadata.
+			cfg.Tags = addTags(cfg.Tags, logTags(resumeFile)...)
+		}
+		sourceFiles = make([][]string, len(messages))
+	} else {
+		var messagesIn []MessageIn
+		if cfg.InputStdinJSON {
+			inputData, err := readAllLimited(reader, cfg.MaxInputBytes)
+			if err != nil {
+				return fmt.Errorf("error reading messages from stdin: %w", err)
+			}
+			if err := json.Unmarshal(inputData, &messagesIn); err != nil {
+				return fmt.Errorf("invalid JSON on stdin: %w", err)
+			}
+
+			// Stdin is used up, so the conversation continues on the
+			// terminal, or ends once the messages are sent without one.
+			tty, err := openTerminal()
+			if err != nil {
+				warnf(s.out, "Warning: no terminal to continue the conversation on, so it ends once the messages from stdin are sent: %v", err)
+			} else {
+				defer tty.Close()
+				reader, terminal = bufio.NewReader(tty), true
+			}
+		} else if cfg.ImportFormat != "" {
+			inputData, err := readInputFile(cfg.ImportFile, cfg.MaxInputBytes)
+			if err != nil {
+				return err
+			}
+
+			messagesIn, err = importMessages(cfg.ImportFormat, inputData)
+			if err != nil {
+				return fmt.Errorf("failed to import %s: %w", cfg.ImportFile, err)
+			}
+		} else if cfg.Messages != "" {
+			if err := json.Unmarshal([]byte(cfg.Messages), &messagesIn); err != nil {
+				return fmt.Errorf("invalid JSON in --messages: %w", err)
+			}
+		} else if inputFile := path.Join(cfg.InputDir, cfg.InputFile); !cfg.NoInput && (cfg.RequireInput || fileExists(inputFile)) {
+			// Without an input file, the session starts from scratch,
+			// unless one was asked for explicitly.
+			inputData, err := readInputFile(inputFile, cfg.MaxInputBytes)
+			if err != nil {
+				return err
+			}
+
+			messagesIn, err = parseInputMessages(inputFile, inputData)
+			if err != nil {
+				return fmt.Errorf("invalid JSON input: %w", err)
+			}
+		}
+
+		messages, sourceFiles, err = loadMessages(s.out, cfg, messagesIn)
+		if err != nil {
+			return err
+		}
+	}
+
+	if cfg.CountOnly {
+		printTokenCounts(s.out, messages, cfg.Tokenizer)
+		return nil
+	}
+
+	if len(cfg.TemperatureRange) > 0 {
+		if err := runTemperatureSweep(s.out, client, cfg, messages); err != nil {
+			return fmt.Errorf("temperature sweep failed: %w", err)
+		}
+		return nil
+	}
+
+	displayInitScreen(s.out, messages, sourceFiles, cfg.Model, float32(cfg.Temperature))
+	if systemMsgsCount, _, _ := countRoles(messages); cfg.WarnNoSystem && systemMsgsCount == 0 {
+		warnf(s.out, "Warning: the conversation has no system message. Add one to the input file to set the model's behavior")
+	}
+
+	savedMsgsCount := len(messages)
+
+	msgsCount := len(messages)
+	prefill := cfg.Prefill && msgsCount > 0 && messages[msgsCount-1].Role == ASSISTANT
+	needInput := !prefill && (msgsCount == 0 || messages[msgsCount-1].Role != USER)
+
+	payload := RequestPayload{
+		Model:       cfg.Model,
+		Temperature: float32(cfg.Temperature),
+	}
+	if cfg.Choices > 1 {
+		payload.N = cfg.Choices
+	}
+	if cfg.Stream {
+		payload.Stream = true
+		payload.StreamOptions = &StreamOptions{IncludeUsage: true}
+	}
+	failed := false
+	attempt := 0
+	var stats sessionStats
+
+	// The deadline is only checked between turns, so the turn in progress
+	// when it passes is completed and saved.
+	ctx := context.Background()
+	if cfg.SessionTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.SessionTimeout)
+		defer cancel()
+	}
+
+	for {
+		if ctx.Err() != nil {
+			fmt.Fprintf(s.out, "Session timeout of %s reached\n", cfg.SessionTimeout)
+			emitEvent(s.out, "error", map[string]string{"message": "session timeout reached"})
+			saveSessionLog(s.out, messages, cfg, annotations)
+			return nil
+		}
+
+		if needInput {
+			userInput, action, err := promptUser(s.out, reader, terminal, messages, savedMsgsCount, failed, &stats, annotations, cfg)
+			if errors.Is(err, errInputClosed) {
+				if cfg.SaveOnExit {
+					saveSessionLog(s.out, messages, cfg, annotations)
+				}
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("failed to read user input: %w", err)
+			}
+
+			switch action {
+			case actionQuit:
+				return nil
+			case actionCompare:
+				if err := compareModels(s.out, client, cfg, messages, userInput); err != nil {
+					fmt.Fprintf(s.out, "!! Comparison failed: %v\n", err)
+				}
+				fmt.Fprintln(s.out)
+				continue
+			case actionLoad:
+				loaded, err := readResumedLog(s.out, annotations, userInput)
+				if err != nil {
+					fmt.Fprintf(s.out, "!! %v\n\n", err)
+					continue
+				}
+				messages = loaded
+				cfg.Tags = addTags(cfg.Tags, logTags(userInput)...)
+				savedMsgsCount = len(messages)
+				failed = false
+				fmt.Fprintf(s.out, "Loaded %d messages from %s\n\n", len(messages), userInput)
+				// Like on startup, a conversation that ends with a user
+				// message is sent right away.
+				needInput = len(messages) == 0 || messages[len(messages)-1].Role != USER
+				continue
+			case actionResend:
+				changes, err := applyResendOverrides(cfg, userInput)
+				if err != nil {
+					fmt.Fprintf(s.out, "!! %v\n\n", err)
+					continue
+				}
+				payload.Temperature = float32(cfg.Temperature)
+				if len(changes) > 0 {
+					fmt.Fprintf(s.out, "Resending with %s\n", strings.Join(changes, ", "))
+				} else {
+					fmt.Fprintln(s.out, "Resending with the same parameters")
+				}
+
+				messages, _ = dropLastReply(messages)
+				savedMsgsCount = min(savedMsgsCount, len(messages))
+				prefill = false
+				failed = false
+				attempt = 0
+			case actionMessage:
+				messages = append(messages, Message{Role: USER, Content: userInput})
+				attempt = 0
+			}
+		} else {
+			// A conversation that ends with a user message, on startup or
+			// once loaded, is sent without going through promptUser.
+			ok, err := confirmPromptSize(s.out, reader, terminal, messages, cfg)
+			if err != nil && !errors.Is(err, errInputClosed) {
+				return fmt.Errorf("failed to read user input: %w", err)
+			}
+			if !ok {
+				if cfg.Once {
+					return fmt.Errorf("the prompt is over the --confirm-over limit and wasn't sent")
+				}
+				needInput = true
+				fmt.Fprintln(s.out)
+				continue
+			}
+		}
+		needInput = true
+
+		attempt++
+		if attempt > 1 {
+			fmt.Fprintf(s.out, "Retrying request (attempt %d)...\n", attempt)
+		}
+
+		payload.Messages = windowMessages(messages, cfg.Window)
+		var responseBody ResponseBody
+		var body []byte
+		start := time.Now()
+		if cfg.Stream {
+			err = streamChatRequest(s.out, client, cfg, payload, &responseBody)
+		} else {
+			body, err = sendChatRequest(s.out, client, cfg, payload, &responseBody)
+		}
+		if err != nil {
+			emitEvent(s.out, "error", map[string]string{"message": err.Error()})
+			if cfg.Once {
+				return err
+			}
+			warnf(s.out, "%v", err)
+			failed = true
+			fmt.Fprintln(s.out, "\n> /retry to resend the request")
+			fmt.Fprintln(s.out)
+			continue
+		}
+
+		if len(responseBody.Choices) > 0 {
+			best := 0
+			if cfg.AutoRerank && len(responseBody.Choices) > 1 {
+				index, reason, err := rerankChoices(s.out, client, cfg, messages, responseBody.Choices)
+				if err != nil {
+					warnf(s.out, "Warning: failed to rerank the answers, using the first one: %v", err)
+				} else {
+					best = index
+					fmt.Fprintf(s.out, "[Picked answer %d of %d: %s]\n", best+1, len(responseBody.Choices), reason)
+				}
+			}
+
+			if cfg.AutoContinue {
+				continueResponse(s.out, client, cfg, payload, &responseBody.Choices[best], &responseBody.Usage, &responseBody.requestIDs)
+			}
+
+			if responseBody.Choices[best].Message.Content == "" {
+				err := emptyResponseError(responseBody.Choices[best])
+				emitEvent(s.out, "error", map[string]string{"message": err.Error()})
+				if cfg.Once {
+					return err
+				}
+				failed = true
+				fmt.Fprintf(s.out, "[%v]\n", err)
+				fmt.Fprintln(s.out, "\n> /retry to resend the request")
+				fmt.Fprintln(s.out)
+				continue
+			}
+
+			assistantMessage := responseBody.Choices[best].Message
+			assistantMessage.Content = postProcess(s.out, cfg, assistantMessage.Content)
+			displayed := displayedContent(s.out, cfg, assistantMessage.Content)
+			if stripsThinkingFromLog(cfg) {
+				assistantMessage.Content = stripThinking(assistantMessage.Content)
+			}
+			if prefill {
+				messages[len(messages)-1].Content += assistantMessage.Content
+				assistantMessage = messages[len(messages)-1]
+				prefill = false
+			} else {
+				messages = append(messages, assistantMessage)
+			}
+			annotations.requests = recordRequests(annotations.requests, len(messages)-1, responseBody.requestIDs)
+			failed = false
+
+			if !cfg.Stream {
+				fmt.Fprintf(s.out, "%s%s\n", cfg.AssistantPrefix, displayed)
+				teeResponse(s.out, cfg, responseBody.Choices[best].Message.Content)
+			}
+			if responseBody.Choices[best].FinishReason == finishReasonLength {
+				fmt.Fprintln(s.out, "[response truncated — increase --max-tokens]")
+			}
+			// The usage of a cancelled or interrupted response is only an
+			// estimate, which is already shown with its note.
+			if reason := responseBody.Choices[best].FinishReason; reason != finishReasonCancelled && reason != finishReasonInterrupted {
+				printResponseUsage(s.out, responseBody)
+			}
+			if cfg.ReplySeparator != "" {
+				fmt.Fprintln(s.out, styled(s.out, ansiDim, cfg.ReplySeparator))
+			}
+			stats.record(responseBody.Usage, time.Since(start))
+			emitEvent(s.out, "response", assistantMessage)
+			emitEvent(s.out, "usage", responseBody.Usage)
+
+			if cfg.Once {
+				if cfg.SaveOnExit {
+					saveSessionLog(s.out, messages, cfg, annotations)
+				}
+				return nil
+			}
+		} else {
+			failed = true
+			emitEvent(s.out, "error", map[string]string{"message": "no response from API", "body": string(body)})
+			if cfg.Once {
+				return fmt.Errorf("no response from API")
+			}
+			fmt.Fprintf(s.out, "!! Error: No response from API\n\n")
+			fmt.Fprintln(s.out, string(body))
+			fmt.Fprintln(s.out, "\n> /retry to resend the request")
+			quit := defaultStopCommands[0]
+			if len(cfg.StopCommands) > 0 {
+				quit = cfg.StopCommands[0]
+			}
+			fmt.Fprintf(s.out, "> %s to save and exit\n", quit)
+			fmt.Fprintf(s.out, "> %s! to exit without saving\n", quit)
+		}
+
+		fmt.Fprintln(s.out)
+	}
+}
@@ -0,0 +1,268 @@
+package chat
+
+import (
+	"bytes"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// runTestSession runs a session with the mock provider, reading the scripted
+// input and saving its logs to a temporary directory. It returns the output
+// of the session and the conversation logs it saved.
+func runTestSession(t *testing.T, input string, args ...string) (string, [][]Message) {
+	t.Helper()
+	t.Setenv("LLM_MODEL", "")
+	logsDir := t.TempDir()
+
+	cfg, err := LoadConfig(append([]string{"--provider", mockProvider, "--no-input", "--logs-dir", logsDir}, args...))
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	var out bytes.Buffer
+	if err := NewSession(cfg, strings.NewReader(input), &out).Run(); err != nil {
+		t.Fatalf("Run() error = %v\n%s", err, out.String())
+	}
+
+	logFiles, err := filepath.Glob(filepath.Join(logsDir, "*", "*.log.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var logs [][]Message
+	for _, logFile := range logFiles {
+		messages, err := readConversationLog(logFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		logs = append(logs, messages)
+	}
+	return out.String(), logs
+}
+
+func TestSessionQuitSavesConversation(t *testing.T) {
+	out, logs := runTestSession(t, "Hello\n/quit now\n")
+
+	if !strings.Contains(out, "echo: Hello") {
+		t.Errorf("output doesn't contain the response:\n%s", out)
+	}
+	want := []Message{{Role: USER, Content: "Hello"}, {Role: ASSISTANT, Content: "echo: Hello"}}
+	if len(logs) != 1 || !equalMessages(logs[0], want) {
+		t.Errorf("saved logs = %v, want [%v]", logs, want)
+	}
+}
+
+func TestSessionQuitWithoutSaving(t *testing.T) {
+	out, logs := runTestSession(t, "Hello\n/quit!\nnot sent\n")
+
+	if len(logs) != 0 {
+		t.Errorf("saved %d logs, want none", len(logs))
+	}
+	if strings.Contains(out, "not sent") {
+		t.Errorf("input after /quit! was sent:\n%s", out)
+	}
+}
+
+func TestSessionResendReplacesReply(t *testing.T) {
+	_, logs := runTestSession(t, "Hello\n/resend --temp 1.5\n/quit\n")
+
+	want := []Message{{Role: USER, Content: "Hello"}, {Role: ASSISTANT, Content: "echo: Hello"}}
+	if len(logs) != 1 || !equalMessages(logs[0], want) {
+		t.Errorf("saved logs = %v, want [%v]", logs, want)
+	}
+}
+
+func TestSessionsKeepTheirOwnOutput(t *testing.T) {
+	t.Setenv("LLM_MODEL", "")
+	args := []string{"--provider", mockProvider, "--messages", `[{"role": "user", "content": "Hi"}]`, "--once", "--logs-dir", t.TempDir()}
+
+	eventsCfg, err := LoadConfig(append(args, "--jsonl-events"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events, eventsText bytes.Buffer
+	if err := NewSession(eventsCfg, strings.NewReader(""), &events, WithErrorOutput(&eventsText)).Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A later session in the same process writes no events.
+	plainCfg, err := LoadConfig(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var plain bytes.Buffer
+	if err := NewSession(plainCfg, strings.NewReader(""), &plain).Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(events.String(), `"type":"response"`) || strings.Contains(events.String(), "<< ") {
+		t.Errorf("events output isn't only events:\n%s", events.String())
+	}
+	if !strings.Contains(eventsText.String(), "echo: Hi") {
+		t.Errorf("error output of the events session doesn't contain the response:\n%s", eventsText.String())
+	}
+	if strings.Contains(plain.String(), `"type":`) || !strings.Contains(plain.String(), "echo: Hi") {
+		t.Errorf("output of the later session:\n%s", plain.String())
+	}
+}
+
+func TestSessionEmptyToolCallResponse(t *testing.T) {
+	for _, stream := range []string{"--stream=false", "--stream"} {
+		out, logs := runTestSession(t, "Hello\n/quit\n", "--mock-response", "testdata/tool_calls_response.json", stream)
+
+		if !strings.Contains(out, "[empty response: the model requested a tool call, which is not supported]") {
+			t.Errorf("%s: output doesn't contain the empty response note:\n%s", stream, out)
+		}
+		want := []Message{{Role: USER, Content: "Hello"}}
+		if len(logs) != 1 || !equalMessages(logs[0], want) {
+			t.Errorf("%s: saved logs = %v, want [%v]", stream, logs, want)
+		}
+	}
+}
+
+func TestSessionTruncatedResponse(t *testing.T) {
+	for _, stream := range []string{"--stream=false", "--stream"} {
+		out, logs := runTestSession(t, "Name the primary colors\n/quit\n", "--mock-response", "testdata/length_response.json", stream)
+
+		if !strings.Contains(out, "The three primary colors are red,") || !strings.Contains(out, "[response truncated — increase --max-tokens]") {
+			t.Errorf("%s: output doesn't contain the truncated response and its notice:\n%s", stream, out)
+		}
+		want := []Message{{Role: USER, Content: "Name the primary colors"}, {Role: ASSISTANT, Content: "The three primary colors are red,"}}
+		if len(logs) != 1 || !equalMessages(logs[0], want) {
+			t.Errorf("%s: saved logs = %v, want [%v]", stream, logs, want)
+		}
+	}
+}
+
+func TestSessionDuplicateMessageWithoutTerminal(t *testing.T) {
+	// The empty responses leave the user messages without a reply, so the
+	// second one repeats the last message of the conversation.
+	out, logs := runTestSession(t, "Hello\nHello\nnext\n/quit\n", "--mock-response", "testdata/tool_calls_response.json")
+
+	if !strings.Contains(out, "identical to the previous user message, sending it again") {
+		t.Errorf("output doesn't warn about the duplicate message:\n%s", out)
+	}
+	want := []Message{{Role: USER, Content: "Hello"}, {Role: USER, Content: "Hello"}, {Role: USER, Content: "next"}}
+	if len(logs) != 1 || !equalMessages(logs[0], want) {
+		t.Errorf("saved logs = %v, want [%v]", logs, want)
+	}
+}
+
+func TestSessionConfirmOverWithoutTerminal(t *testing.T) {
+	// The line after the prompt isn't taken as the confirmation.
+	out, logs := runTestSession(t, "Hello\ny\n/quit\n", "--confirm-over", "1")
+
+	if !strings.Contains(out, "there's no terminal to confirm sending it") || strings.Contains(out, "echo:") {
+		t.Errorf("a prompt over the limit was sent:\n%s", out)
+	}
+	if len(logs) > 1 || len(logs) == 1 && len(logs[0]) != 0 {
+		t.Errorf("saved logs = %v, want no messages", logs)
+	}
+}
+
+func TestSessionConfirmOverInitialSend(t *testing.T) {
+	messages := `[{"role": "user", "content": "Hello"}]`
+	out, _ := runTestSession(t, "/quit!\n", "--messages", messages, "--confirm-over", "1")
+	if !strings.Contains(out, "there's no terminal to confirm sending it") || strings.Contains(out, "echo:") {
+		t.Errorf("a conversation over the limit was sent on startup:\n%s", out)
+	}
+
+	cfg, err := LoadConfig([]string{"--provider", mockProvider, "--messages", messages, "--confirm-over", "1", "--once", "--logs-dir", t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewSession(cfg, strings.NewReader(""), io.Discard).Run(); err == nil {
+		t.Error("Run() with --once succeeded, want an error for the prompt over the limit")
+	}
+}
+
+func TestSessionTraceSavesRequestIDs(t *testing.T) {
+	t.Setenv("LLM_MODEL", "")
+	logsDir := t.TempDir()
+	cfg, err := LoadConfig([]string{"--provider", mockProvider, "--no-input", "--logs-dir", logsDir, "--trace"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewSession(cfg, strings.NewReader("Hello\nAgain\n/resend\n/quit\n"), io.Discard).Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	metadataFiles, err := filepath.Glob(filepath.Join(logsDir, "*", "*.meta.json"))
+	if err != nil || len(metadataFiles) != 1 {
+		t.Fatalf("found metadata files %v, %v, want one", metadataFiles, err)
+	}
+	metadata, err := readLogMetadata(metadataFiles[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metadata.Requests) != 2 {
+		t.Fatalf("saved requests = %v, want one for each assistant message", metadata.Requests)
+	}
+	for i, request := range metadata.Requests {
+		if request.Message != 2*i+1 || len(request.IDs) != 1 || request.IDs[0] == "" {
+			t.Errorf("saved request %d = %v, want one ID for message %d", i, request, 2*i+1)
+		}
+	}
+}
+
+func TestSessionsSharingConfigKeepTheirOwnAnnotations(t *testing.T) {
+	t.Setenv("LLM_MODEL", "")
+	logsDir := t.TempDir()
+	cfg, err := LoadConfig([]string{"--provider", mockProvider, "--no-input", "--logs-dir", logsDir, "--log-naming", hashLogNaming, "--trace"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, input := range []string{"First\n/note from the first session\n/quit\n", "Second\n/quit\n"} {
+		if err := NewSession(cfg, strings.NewReader(input), io.Discard).Run(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	metadataFiles, err := filepath.Glob(filepath.Join(logsDir, "*", "*.meta.json"))
+	if err != nil || len(metadataFiles) != 2 {
+		t.Fatalf("found metadata files %v, %v, want two", metadataFiles, err)
+	}
+	notes, requests := 0, 0
+	for _, metadataFile := range metadataFiles {
+		metadata, err := readLogMetadata(metadataFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		notes += len(metadata.Notes)
+		requests += len(metadata.Requests)
+	}
+	if notes != 1 {
+		t.Errorf("the logs of both sessions hold %d notes, want only the one of the first session", notes)
+	}
+	if requests != 2 {
+		t.Errorf("the logs of both sessions hold %d traced requests, want one each", requests)
+	}
+}
+
+func TestSessionWithoutModelNeedsTerminal(t *testing.T) {
+	t.Setenv("LLM_MODEL", "")
+	// The check is left to the session, which knows whether its own input
+	// is a terminal, rather than to LoadConfig.
+	cfg, err := LoadConfig([]string{"--url", "http://127.0.0.1:1/v1/chat/completions", "--api-key", "sk-test", "--logs-dir", t.TempDir()})
+	if err != nil {
+		t.Fatalf("LoadConfig() without a model: %v", err)
+	}
+
+	err = NewSession(cfg, strings.NewReader("1\n"), io.Discard).Run()
+	if err == nil || !strings.Contains(err.Error(), "missing LLM model") {
+		t.Errorf("Run() without a model nor a terminal: error = %v, want the missing model error", err)
+	}
+}
+
+func equalMessages(a, b []Message) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Role != b[i].Role || a[i].Content != b[i].Content {
+			return false
+		}
+	}
+	return true
+}
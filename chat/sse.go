@@ -0,0 +1,65 @@
+package chat
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// sseScanner reads the data of the events in a Server-Sent Events stream.
+// As in the specification, an event is made of the lines up to a blank line,
+// and the values of its data fields are joined with newlines. Comment lines,
+// which start with a colon and are often sent as keep-alives, and fields
+// other than data are ignored, as are events without data.
+type sseScanner struct {
+	scanner *bufio.Scanner
+	data    string
+}
+
+func newSSEScanner(r io.Reader) *sseScanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	return &sseScanner{scanner: scanner}
+}
+
+// Scan advances to the next event with data, which is then available through
+// Data. It returns false when the stream ends or fails to be read. A last
+// event that isn't followed by a blank line is still returned, unless reading
+// the stream failed.
+func (s *sseScanner) Scan() bool {
+	var lines []string
+	for s.scanner.Scan() {
+		line := s.scanner.Text()
+		if line == "" {
+			if lines != nil {
+				s.data = strings.Join(lines, "\n")
+				return true
+			}
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+
+		field, value, _ := strings.Cut(line, ":")
+		if field == "data" {
+			lines = append(lines, strings.TrimPrefix(value, " "))
+		}
+	}
+
+	if lines != nil && s.scanner.Err() == nil {
+		s.data = strings.Join(lines, "\n")
+		return true
+	}
+	return false
+}
+
+// Data returns the data of the event read by the last call to Scan.
+func (s *sseScanner) Data() string {
+	return s.data
+}
+
+// Err returns the first error reading the stream, if any.
+func (s *sseScanner) Err() error {
+	return s.scanner.Err()
+}
@@ -0,0 +1,94 @@
+package chat
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func scanSSE(t *testing.T, stream string) []string {
+	t.Helper()
+	scanner := newSSEScanner(strings.NewReader(stream))
+	var events []string
+	for scanner.Scan() {
+		events = append(events, scanner.Data())
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("scanning %q: %v", stream, err)
+	}
+	return events
+}
+
+func TestSSEScannerHeartbeats(t *testing.T) {
+	stream, err := os.ReadFile("testdata/heartbeat_stream.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		`{"choices":[{"delta":{"role":"assistant","content":""}}]}`,
+		`{"choices":[{"delta":{"content":"Hello"}}]}`,
+		`{"choices":[{"delta":{"content":", world"}}]}`,
+		`{"choices":[{"delta":{"content":"!"}}]}`,
+		`{"choices":[{"delta":{},"finish_reason":"stop"}]}`,
+		`{"choices":[],"usage":{"prompt_tokens":5,"completion_tokens":3}}`,
+		"[DONE]",
+	}
+	events := scanSSE(t, string(stream))
+	if strings.Join(events, "\n---\n") != strings.Join(want, "\n---\n") {
+		t.Errorf("events = %q, want %q", events, want)
+	}
+}
+
+func TestSSEScannerEvents(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream string
+		want   []string
+	}{
+		{"multi-line data", "data: first\ndata: second\n\n", []string{"first\nsecond"}},
+		{"no space after colon", "data:tight\n\n", []string{"tight"}},
+		{"comments only", ": ping\n\n: ping\n\n", nil},
+		{"comment inside an event", "data: a\n: ping\ndata: b\n\n", []string{"a\nb"}},
+		{"last event without a blank line", "data: a\n\ndata: b", []string{"a", "b"}},
+		{"event without data", "event: ping\n\ndata: a\n\n", []string{"a"}},
+	}
+	for _, test := range tests {
+		events := scanSSE(t, test.stream)
+		if strings.Join(events, "|") != strings.Join(test.want, "|") || len(events) != len(test.want) {
+			t.Errorf("%s: events = %q, want %q", test.name, events, test.want)
+		}
+	}
+}
+
+func TestStreamWithHeartbeats(t *testing.T) {
+	stream, err := os.ReadFile("testdata/heartbeat_stream.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write(stream)
+	}))
+	defer server.Close()
+
+	cfg, err := LoadConfig([]string{"--url", server.URL, "--api-key", "test", "--model", "gpt-4o", "--stream", "--no-input", "--logs-dir", t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var responseBody ResponseBody
+	payload := RequestPayload{Model: cfg.Model, Stream: true, Messages: []Message{{Role: USER, Content: "Hi"}}}
+	if err := streamChatRequest(&bytes.Buffer{}, server.Client(), cfg, payload, &responseBody); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(responseBody.Choices) != 1 || responseBody.Choices[0].Message.Content != "Hello, world!" || responseBody.Choices[0].FinishReason != "stop" {
+		t.Errorf("streamed choices = %+v, want the content \"Hello, world!\" finished by stop", responseBody.Choices)
+	}
+	if usage := responseBody.Usage; usage == nil || usage.PromptTokens != 5 || usage.CompletionTokens != 3 {
+		t.Errorf("streamed usage = %+v, want 5 prompt and 3 completion tokens", usage)
+	}
+}
@@ -0,0 +1,48 @@
+package chat
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// sessionStats accumulates the metrics shown by the /stats command for the
+// responses received during the session.
+type sessionStats struct {
+	turns            int
+	promptTokens     int
+	completionTokens int
+	missingUsage     int
+	latency          time.Duration
+}
+
+func (s *sessionStats) record(usage *Usage, latency time.Duration) {
+	s.turns++
+	s.latency += latency
+	if usage == nil {
+		s.missingUsage++
+		return
+	}
+	s.promptTokens += usage.PromptTokens
+	s.completionTokens += usage.CompletionTokens
+}
+
+func (s *sessionStats) print(out io.Writer, cfg *Config) {
+	fmt.Fprintf(out, "Model: %s (temperature %.2f)\n", cfg.Model, cfg.Temperature)
+	fmt.Fprintf(out, "Turns: %d\n", s.turns)
+	fmt.Fprintf(out, "Tokens: %d input, %d output\n", s.promptTokens, s.completionTokens)
+	if s.missingUsage > 0 {
+		fmt.Fprintf(out, "  (%d responses did not report usage)\n", s.missingUsage)
+	}
+
+	if cfg.InputPrice > 0 || cfg.OutputPrice > 0 {
+		cost := (float64(s.promptTokens)*cfg.InputPrice + float64(s.completionTokens)*cfg.OutputPrice) / 1_000_000
+		fmt.Fprintf(out, "Estimated cost: $%.4f\n", cost)
+	} else {
+		fmt.Fprintln(out, "Estimated cost: unknown (set --input-price and --output-price)")
+	}
+
+	if s.turns > 0 {
+		fmt.Fprintf(out, "Average latency: %.2fs\n", (s.latency / time.Duration(s.turns)).Seconds())
+	}
+}
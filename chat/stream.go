@@ -0,0 +1,354 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+)
+
+// finishReasonCancelled is the finish reason of a streamed response stopped
+// with Ctrl+C.
+const finishReasonCancelled = "cancelled"
+
+// finishReasonInterrupted is the finish reason of a streamed response whose
+// connection dropped before it ended, kept with --keep-partial.
+const finishReasonInterrupted = "interrupted"
+
+// minSpeedSample is the shortest generation time whose speed is reported.
+// Responses that arrive faster, often in a single read, can't be timed.
+const minSpeedSample = 100 * time.Millisecond
+
+// errStreamInterrupted is returned when a response stream ends before the
+// provider marked it as done, typically because the connection dropped.
+var errStreamInterrupted = errors.New("response stream interrupted")
+
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+type StreamDelta struct {
+	Content string `json:"content"`
+	// Reasoning models stream their thinking separately from the answer,
+	// under one of these fields depending on the provider.
+	ReasoningContent string `json:"reasoning_content"`
+	Reasoning        string `json:"reasoning"`
+}
+
+type StreamChoice struct {
+	Delta        StreamDelta `json:"delta"`
+	FinishReason string      `json:"finish_reason"`
+}
+
+type StreamChunk struct {
+	Choices []StreamChoice `json:"choices"`
+	Usage   *Usage         `json:"usage"`
+}
+
+// streamPrinter displays a streamed response, keeping the reasoning of
+// reasoning models visually apart from the answer, even when their deltas
+// are interleaved.
+type streamPrinter struct {
+	out         io.Writer
+	prefix      string
+	started     bool
+	inReasoning bool
+	// shown reports whether any content has been displayed, which is not
+	// the case while the content is only made of removed <think> blocks.
+	shown     bool
+	thinking  *thinkingFilter
+	markdown  *markdownRenderer
+	content   strings.Builder
+	reasoning strings.Builder
+	finished  bool
+	// delay is the typewriter delay between printed characters, which
+	// stops applying once cancelled is closed.
+	delay     time.Duration
+	cancelled <-chan struct{}
+	// tee receives the content as it arrives, so that a long response isn't
+	// lost if the application crashes mid-stream. Writes to a file aren't
+	// buffered, so every delta reaches it right away.
+	tee *os.File
+}
+
+func (p *streamPrinter) writeReasoning(text string) {
+	if !p.inReasoning {
+		if p.started {
+			fmt.Fprintln(p.out)
+		}
+		fmt.Fprint(p.out, styled(p.out, ansiDim, "[reasoning] "))
+		p.started = true
+		p.inReasoning = true
+	}
+	p.reasoning.WriteString(text)
+	p.typewrite(text, ansiDim)
+}
+
+func (p *streamPrinter) writeContent(text string) {
+	p.content.WriteString(text)
+	p.writeTee(text)
+
+	if p.thinking != nil {
+		text = p.thinking.write(text)
+		if !p.shown {
+			text = strings.TrimLeft(text, " \t\r\n")
+		}
+		if text == "" {
+			return
+		}
+	}
+	if p.markdown != nil {
+		text = p.markdown.write(text)
+		if text == "" {
+			return
+		}
+	}
+
+	if p.inReasoning {
+		fmt.Fprint(p.out, "\n\n")
+		p.inReasoning = false
+		if !p.shown {
+			fmt.Fprint(p.out, p.prefix)
+		}
+	} else if !p.started {
+		fmt.Fprint(p.out, p.prefix)
+	}
+	p.started = true
+	p.shown = true
+
+	p.typewrite(text, "")
+}
+
+// typewrite prints text with the given ANSI style, if any, one character at
+// a time when a typewriter delay is set. The delay is cut short once the
+// response is cancelled, leaving the rest of the text unprinted.
+func (p *streamPrinter) typewrite(text string, style string) {
+	write := func(text string) {
+		if style != "" {
+			text = styled(p.out, style, text)
+		}
+		fmt.Fprint(p.out, text)
+	}
+
+	if p.delay <= 0 {
+		write(text)
+		return
+	}
+
+	for _, r := range text {
+		write(string(r))
+		select {
+		case <-p.cancelled:
+			return
+		case <-time.After(p.delay):
+		}
+	}
+}
+
+func (p *streamPrinter) writeTee(text string) {
+	if p.tee == nil {
+		return
+	}
+	if _, err := p.tee.WriteString(text); err != nil {
+		warnf(p.out, "Warning: failed to write to tee file, no longer writing to it: %v", err)
+		p.tee.Close()
+		p.tee = nil
+	}
+}
+
+func (p *streamPrinter) finish() {
+	if p.finished {
+		return
+	}
+	p.finished = true
+
+	text := ""
+	if p.thinking != nil {
+		text = p.thinking.flush()
+	}
+	if p.markdown != nil {
+		text = p.markdown.write(text) + p.markdown.flush()
+	}
+	if text != "" && p.started {
+		fmt.Fprint(p.out, text)
+	}
+	if p.started {
+		fmt.Fprintln(p.out)
+	}
+	if p.tee != nil {
+		if p.content.Len() > 0 {
+			p.writeTee("\n")
+		}
+		p.tee.Close()
+	}
+}
+
+// streamChatRequest sends a streaming chat request, printing the response
+// content as it arrives. Once the stream ends, responseBody holds the full
+// assistant message and, when the provider reports it in the final chunk,
+// the token usage. Reasoning is displayed but not kept in the message.
+//
+// Ctrl+C stops the response instead of exiting, keeping the content
+// generated so far, with its usage estimated from the tokens received.
+//
+// Streams interrupted before they end are retried up to cfg.MaxRetries
+// times. Once retries are exhausted, the partial response is kept when
+// cfg.KeepPartial is set, and an error wrapping errStreamInterrupted is
+// returned otherwise.
+func streamChatRequest(out io.Writer, client *http.Client, cfg *Config, payload RequestPayload, responseBody *ResponseBody) error {
+	for retry := 0; ; retry++ {
+		err := streamResponse(out, client, cfg, payload, responseBody)
+		if !errors.Is(err, errStreamInterrupted) {
+			return err
+		}
+
+		if retry >= cfg.MaxRetries {
+			if cfg.KeepPartial && responseBody.Choices[0].Message.Content != "" {
+				warnf(out, "Warning: %v, keeping the partial response", err)
+				return nil
+			}
+			return err
+		}
+
+		warnf(out, "Warning: %v, retrying (%d/%d)", err, retry+1, cfg.MaxRetries)
+		time.Sleep(retryDelay(retry, cfg.RetryJitter))
+	}
+}
+
+// streamResponse sends a streaming chat request once. A stream counts as
+// complete once the provider sends [DONE] or a finish reason; ending before
+// either, on a read error or a premature end of file, interrupts it.
+func streamResponse(out io.Writer, client *http.Client, cfg *Config, payload RequestPayload, responseBody *ResponseBody) error {
+	resp, err := doChatRequest(out, client, cfg, payload)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	printer := &streamPrinter{out: out, prefix: cfg.AssistantPrefix, tee: openTee(out, cfg)}
+	if stripsThinkingFromDisplay(cfg) {
+		printer.thinking = &thinkingFilter{}
+	}
+	if rendersMarkdown(out, cfg) {
+		printer.markdown = &markdownRenderer{}
+	}
+	defer printer.finish()
+
+	interrupted, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	printer.delay = cfg.TypewriterDelay
+	printer.cancelled = interrupted.Done()
+	go func() {
+		// Closing the body unblocks the stream reading below.
+		<-interrupted.Done()
+		resp.Body.Close()
+	}()
+
+	var usage *Usage
+	finishReason := ""
+	done := false
+	// The generation speed is measured from the first delta to the last,
+	// leaving out the time to the first token.
+	var firstDelta, lastDelta time.Time
+
+	events := newSSEScanner(resp.Body)
+	for events.Scan() {
+		data := events.Data()
+		if strings.TrimSpace(data) == "[DONE]" {
+			done = true
+			break
+		}
+
+		remapped, err := remapFields([]byte(data), responseFields(cfg))
+		if err != nil {
+			return traceError(resp.Request, fmt.Errorf("error unmarshalling stream chunk: %w", err))
+		}
+
+		var chunk StreamChunk
+		if err := json.Unmarshal(remapped, &chunk); err != nil {
+			return traceError(resp.Request, fmt.Errorf("error unmarshalling stream chunk: %w", err))
+		}
+
+		if chunk.Usage != nil {
+			usage = chunk.Usage
+		}
+		if len(chunk.Choices) == 0 {
+			continue
+		}
+
+		if chunk.Choices[0].FinishReason != "" {
+			finishReason = chunk.Choices[0].FinishReason
+		}
+
+		delta := chunk.Choices[0].Delta
+		if delta.ReasoningContent+delta.Reasoning+delta.Content != "" {
+			lastDelta = time.Now()
+			if firstDelta.IsZero() {
+				firstDelta = lastDelta
+			}
+		}
+		if reasoning := delta.ReasoningContent + delta.Reasoning; reasoning != "" {
+			printer.writeReasoning(reasoning)
+			emitEvent(out, "chunk", map[string]string{"reasoning_content": reasoning})
+		}
+		if delta.Content != "" {
+			printer.writeContent(delta.Content)
+			emitEvent(out, "chunk", map[string]string{"content": delta.Content})
+		}
+	}
+	// The response received so far, with its usage estimated, is kept when
+	// the stream is cut short.
+	partial := func(finishReason string) int {
+		generated := countTokens(printer.reasoning.String(), cfg.Tokenizer) + countTokens(printer.content.String(), cfg.Tokenizer)
+		printer.finish()
+		*responseBody = ResponseBody{
+			Choices: []ResponseChoice{{
+				Message:      Message{Role: ASSISTANT, Content: printer.content.String()},
+				FinishReason: finishReason,
+			}},
+			Usage:      &Usage{CompletionTokens: generated},
+			requestIDs: tracedRequestIDs(resp.Request),
+		}
+		return generated
+	}
+
+	if interrupted.Err() != nil {
+		generated := partial(finishReasonCancelled)
+		fmt.Fprintln(out, styled(out, ansiDim, fmt.Sprintf("[cancelled after ~%d tokens]", generated)))
+		emitEvent(out, "cancelled", map[string]int{"completion_tokens": generated})
+		return nil
+	}
+	if err := events.Err(); err != nil || (!done && finishReason == "") {
+		if err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+		generated := partial(finishReasonInterrupted)
+		fmt.Fprintln(out, styled(out, ansiDim, fmt.Sprintf("[stream interrupted after ~%d tokens]", generated)))
+		return traceError(resp.Request, fmt.Errorf("%w: %w", errStreamInterrupted, err))
+	}
+
+	*responseBody = ResponseBody{
+		Choices: []ResponseChoice{{
+			Message:      Message{Role: ASSISTANT, Content: printer.content.String()},
+			FinishReason: finishReason,
+		}},
+		Usage:      usage,
+		requestIDs: tracedRequestIDs(resp.Request),
+	}
+	if elapsed := lastDelta.Sub(firstDelta); elapsed >= minSpeedSample {
+		generated := 0
+		if usage != nil {
+			generated = usage.CompletionTokens
+		} else {
+			generated = countTokens(printer.reasoning.String(), cfg.Tokenizer) + countTokens(printer.content.String(), cfg.Tokenizer)
+		}
+		responseBody.tokensPerSecond = float64(generated) / elapsed.Seconds()
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package chat
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// completeConversation sends a single, non-streaming request and returns the
+// post-processed assistant message along with the token usage.
+func completeConversation(out io.Writer, client *http.Client, cfg *Config, payload RequestPayload) (Message, *Usage, error) {
+	payload.Messages = windowMessages(payload.Messages, cfg.Window)
+
+	var responseBody ResponseBody
+	if _, err := sendChatRequest(out, client, cfg, payload, &responseBody); err != nil {
+		return Message{}, nil, err
+	}
+	if len(responseBody.Choices) == 0 {
+		return Message{}, nil, fmt.Errorf("no response from API")
+	}
+	if responseBody.Choices[0].Message.Content == "" {
+		return Message{}, nil, emptyResponseError(responseBody.Choices[0])
+	}
+
+	assistantMessage := responseBody.Choices[0].Message
+	assistantMessage.Content = postProcess(out, cfg, assistantMessage.Content)
+	if stripsThinkingFromLog(cfg) {
+		assistantMessage.Content = stripThinking(assistantMessage.Content)
+	}
+	return assistantMessage, responseBody.Usage, nil
+}
+
+// runTemperatureSweep sends the same conversation once per configured
+// temperature, printing each reply under its own label and saving every
+// result to a separate conversation log.
+func runTemperatureSweep(out io.Writer, client *http.Client, cfg *Config, messages []Message) error {
+	if len(messages) == 0 || messages[len(messages)-1].Role != USER {
+		return fmt.Errorf("the conversation must end with a user message")
+	}
+
+	failures := 0
+	for _, temperature := range cfg.TemperatureRange {
+		fmt.Fprintf(out, "=== Temperature: %.2f ===\n\n", temperature)
+
+		payload := RequestPayload{
+			Model:       cfg.Model,
+			Messages:    messages,
+			Temperature: float32(temperature),
+		}
+
+		assistantMessage, usage, err := completeConversation(out, client, cfg, payload)
+		if err != nil {
+			warnf(out, "%v", err)
+			emitEvent(out, "error", map[string]any{"message": err.Error(), "temperature": temperature})
+			failures++
+			fmt.Fprintln(out)
+			continue
+		}
+
+		fmt.Fprintf(out, "%s%s\n", cfg.AssistantPrefix, assistantMessage.Content)
+		printUsage(out, usage)
+		emitEvent(out, "response", map[string]any{"message": assistantMessage, "temperature": temperature})
+		emitEvent(out, "usage", usage)
+
+		conversation := append(messages[:len(messages):len(messages)], assistantMessage)
+		metadata := LogMetadata{Model: cfg.Model, Temperature: temperature, Tags: cfg.Tags, Config: savedConfig(cfg)}
+		if err := saveConversationLog(out, conversation, metadata, cfg.LogsDir, cfg.ExportFormat); err != nil {
+			warnf(out, "Error saving conversation log: %v", err)
+		}
+		fmt.Fprintln(out)
+	}
+
+	if failures > 0 {
+		return fmt.Errorf("%d of %d requests failed", failures, len(cfg.TemperatureRange))
+	}
+	return nil
+}
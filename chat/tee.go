@@ -0,0 +1,34 @@
+package chat
+
+import (
+	"io"
+	"os"
+)
+
+// openTee opens the --tee file for appending, or returns nil when it isn't
+// set or can't be opened.
+func openTee(out io.Writer, cfg *Config) *os.File {
+	if cfg.Tee == "" {
+		return nil
+	}
+
+	file, err := os.OpenFile(cfg.Tee, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		warnf(out, "Warning: failed to open tee file: %v", err)
+		return nil
+	}
+	return file
+}
+
+// teeResponse appends a complete response to the --tee file.
+func teeResponse(out io.Writer, cfg *Config, content string) {
+	file := openTee(out, cfg)
+	if file == nil {
+		return
+	}
+	defer file.Close()
+
+	if _, err := file.WriteString(content + "\n"); err != nil {
+		warnf(out, "Warning: failed to write to tee file: %v", err)
+	}
+}
@@ -0,0 +1,50 @@
+package chat
+
+import (
+	"fmt"
+	"strings"
+)
+
+// chatTemplateExtension is the extension of conversation templates, plain
+// text files split into messages by role markers.
+const chatTemplateExtension = ".chat"
+
+// parseChatTemplate reads a conversation template, where each message starts
+// with a line holding only its role after a hash sign, e.g. "#system" or
+// "#user", and runs until the next marker. Roles accept the same aliases as
+// input files. The blank lines around each message are trimmed, while those
+// within it are kept. Lines starting with a hash sign that isn't followed by
+// a role, such as Markdown headings, are content.
+func parseChatTemplate(data []byte) ([]MessageIn, error) {
+	var messagesIn []MessageIn
+	var lines []string
+	addMessage := func() {
+		if len(messagesIn) > 0 {
+			content := strings.Trim(strings.Join(lines, "\n"), "\n")
+			messagesIn[len(messagesIn)-1].Content = content
+		}
+		lines = nil
+	}
+
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+	for i, line := range strings.Split(text, "\n") {
+		if marker, ok := strings.CutPrefix(strings.TrimRight(line, " \t"), "#"); ok && marker != "" {
+			if role, err := normalizeRole(MsgRole(marker)); err == nil && !strings.ContainsAny(marker, " \t") {
+				addMessage()
+				messagesIn = append(messagesIn, MessageIn{Role: role})
+				continue
+			}
+		}
+
+		if len(messagesIn) == 0 && strings.TrimSpace(line) != "" {
+			return nil, fmt.Errorf("line %d: text before the first role marker, such as #system or #user", i+1)
+		}
+		lines = append(lines, line)
+	}
+	addMessage()
+
+	if messagesIn == nil {
+		messagesIn = []MessageIn{}
+	}
+	return messagesIn, nil
+}
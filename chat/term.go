@@ -0,0 +1,93 @@
+package chat
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+)
+
+const (
+	ansiBold  = "\x1b[1m"
+	ansiDim   = "\x1b[2m"
+	ansiRed   = "\x1b[31m"
+	ansiGreen = "\x1b[32m"
+	ansiCyan  = "\x1b[36m"
+	ansiReset = "\x1b[0m"
+
+	ansiClearLine = "\x1b[2K"
+)
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// ansiTerminal reports whether f is a terminal that processes ANSI escape
+// sequences, enabling them on the Windows consoles that support them.
+func ansiTerminal(f *os.File) bool {
+	return isTerminal(f) && enableVirtualTerminal(f)
+}
+
+// openTerminal opens the terminal of the process for reading, so that a
+// session whose stdin was used for its messages can still prompt the user.
+func openTerminal() (*os.File, error) {
+	if runtime.GOOS == "windows" {
+		return os.Open("CONIN$")
+	}
+	return os.Open("/dev/tty")
+}
+
+// useColor reports whether ANSI styles should be written to out. Output that
+// is redirected, disabled through NO_COLOR, or to a legacy Windows console
+// without VT support stays plain text.
+func useColor(out io.Writer) bool {
+	f, ok := outputFile(out)
+	return ok && os.Getenv("NO_COLOR") == "" && ansiTerminal(f)
+}
+
+// outputFile returns the file that out writes to, if any, looking through the
+// output of a session.
+func outputFile(out io.Writer) (*os.File, bool) {
+	if output, ok := out.(*sessionOutput); ok {
+		out = output.Writer
+	}
+	f, ok := out.(*os.File)
+	return f, ok
+}
+
+// waitingIndicator is the text shown while waiting for a response, which is
+// erased once the response arrives.
+type waitingIndicator struct {
+	out   io.Writer
+	shown bool
+}
+
+// showWaiting prints the --waiting-text to out if it is a terminal where it
+// can be erased afterwards. Batch mode prints its own progress instead.
+func showWaiting(out io.Writer, cfg *Config) *waitingIndicator {
+	f, ok := outputFile(out)
+	if cfg.WaitingText == "" || cfg.BatchDir != "" || !ok || !ansiTerminal(f) {
+		return &waitingIndicator{}
+	}
+
+	fmt.Fprint(out, styled(out, ansiDim, cfg.WaitingText))
+	return &waitingIndicator{out: out, shown: true}
+}
+
+func (w *waitingIndicator) clear() {
+	if !w.shown {
+		return
+	}
+	fmt.Fprint(w.out, "\r"+ansiClearLine)
+	w.shown = false
+}
+
+// styled returns text with the given ANSI style, if out is the output of a
+// session that writes styles.
+func styled(out io.Writer, style string, text string) string {
+	if output, ok := out.(*sessionOutput); !ok || !output.color {
+		return text
+	}
+	return style + text + ansiReset
+}
@@ -0,0 +1,11 @@
+//go:build !windows
+
+package chat
+
+import "os"
+
+// enableVirtualTerminal reports whether the terminal of f processes ANSI
+// escape sequences, which terminals outside Windows always do.
+func enableVirtualTerminal(f *os.File) bool {
+	return true
+}
@@ -0,0 +1,110 @@
+package chat
+
+import (
+	"io"
+	"strings"
+)
+
+const (
+	thinkOpenTag  = "<think>"
+	thinkCloseTag = "</think>"
+
+	// Where --strip-thinking removes the <think> blocks from.
+	stripThinkingDisplay = "display"
+	stripThinkingLog     = "log"
+	stripThinkingBoth    = "both"
+)
+
+func stripsThinkingFromDisplay(cfg *Config) bool {
+	return cfg.StripThinking == stripThinkingDisplay || cfg.StripThinking == stripThinkingBoth
+}
+
+func stripsThinkingFromLog(cfg *Config) bool {
+	return cfg.StripThinking == stripThinkingLog || cfg.StripThinking == stripThinkingBoth
+}
+
+// displayedContent returns content as it is shown to the user.
+func displayedContent(out io.Writer, cfg *Config, content string) string {
+	if stripsThinkingFromDisplay(cfg) {
+		content = stripThinking(content)
+	}
+	if rendersMarkdown(out, cfg) {
+		content = renderMarkdown(content)
+	}
+	return content
+}
+
+// stripThinking removes the <think> blocks from content, along with the
+// whitespace they leave around the answer.
+func stripThinking(content string) string {
+	var filter thinkingFilter
+	stripped := filter.write(content) + filter.flush()
+	if !filter.removed {
+		return content
+	}
+	return strings.TrimSpace(stripped)
+}
+
+// thinkingFilter removes <think> blocks from text that may arrive in pieces,
+// as when streaming, holding back a partial tag until the next piece shows
+// whether it is one. Tags are case-insensitive, nested blocks are removed
+// with their outermost block, a stray closing tag is dropped, and a block
+// that is never closed, as in a truncated response, runs to the end.
+type thinkingFilter struct {
+	depth   int
+	pending string
+	removed bool
+}
+
+func (f *thinkingFilter) write(text string) string {
+	text = f.pending + text
+	f.pending = ""
+
+	var visible strings.Builder
+	for text != "" {
+		i := strings.IndexByte(text, '<')
+		if i < 0 {
+			f.emit(&visible, text)
+			break
+		}
+		f.emit(&visible, text[:i])
+		text = text[i:]
+
+		lower := strings.ToLower(text)
+		switch {
+		case strings.HasPrefix(lower, thinkOpenTag):
+			f.depth++
+			f.removed = true
+			text = text[len(thinkOpenTag):]
+		case strings.HasPrefix(lower, thinkCloseTag):
+			f.depth = max(f.depth-1, 0)
+			f.removed = true
+			text = text[len(thinkCloseTag):]
+		case strings.HasPrefix(thinkOpenTag, lower) || strings.HasPrefix(thinkCloseTag, lower):
+			f.pending = text
+			text = ""
+		default:
+			f.emit(&visible, text[:1])
+			text = text[1:]
+		}
+	}
+
+	return visible.String()
+}
+
+func (f *thinkingFilter) emit(visible *strings.Builder, text string) {
+	if f.depth == 0 {
+		visible.WriteString(text)
+	}
+}
+
+// flush returns the text held back as a possible partial tag, once no more
+// text is going to arrive.
+func (f *thinkingFilter) flush() string {
+	pending := f.pending
+	f.pending = ""
+	if f.depth > 0 {
+		return ""
+	}
+	return pending
+}
@@ -0,0 +1,91 @@
+package chat
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/pkoukk/tiktoken-go"
+)
+
+const (
+	defaultTokenizer   = "cl100k_base"
+	heuristicTokenizer = "heuristic"
+
+	// Tokens added by the chat format around every message, and to prime the
+	// assistant reply, as documented by OpenAI for its chat models.
+	tokensPerMessage = 3
+	tokensPerReply   = 3
+)
+
+// tokenizers caches the BPE encodings by name, shared by every session of
+// the process since they don't change once loaded.
+var tokenizers = struct {
+	sync.Mutex
+	encodings map[string]*tiktoken.Tiktoken
+	errs      map[string]error
+}{encodings: map[string]*tiktoken.Tiktoken{}, errs: map[string]error{}}
+
+// loadTokenizer returns the BPE encoding with the given name, or nil when the
+// encoding isn't available and token counts should fall back to an estimate,
+// along with the reason why. Encodings are downloaded on first use and
+// cached in TIKTOKEN_CACHE_DIR.
+func loadTokenizer(name string) (*tiktoken.Tiktoken, error) {
+	if name == heuristicTokenizer {
+		return nil, nil
+	}
+
+	tokenizers.Lock()
+	defer tokenizers.Unlock()
+	if encoding, ok := tokenizers.encodings[name]; ok {
+		return encoding, tokenizers.errs[name]
+	}
+
+	encoding, err := tiktoken.GetEncoding(name)
+	if err != nil {
+		err = fmt.Errorf("tokenizer \"%s\" is not available, token counts are estimated: %w", name, err)
+	}
+	tokenizers.encodings[name] = encoding
+	tokenizers.errs[name] = err
+	return encoding, err
+}
+
+func countTokens(text string, tokenizer string) int {
+	if encoding, _ := loadTokenizer(tokenizer); encoding != nil {
+		return len(encoding.Encode(text, nil, nil))
+	}
+
+	return (utf8.RuneCountInString(text) + 3) / 4
+}
+
+// countMessagesTokens counts the prompt tokens for messages. The second
+// return value reports whether the count is exact or only an estimate.
+func countMessagesTokens(messages []Message, tokenizer string) (int, bool) {
+	total := tokensPerReply
+	for _, msg := range messages {
+		total += tokensPerMessage + countTokens(string(msg.Role), tokenizer) + countTokens(msg.Content, tokenizer)
+	}
+
+	encoding, _ := loadTokenizer(tokenizer)
+	return total, encoding != nil
+}
+
+// printTokenCounts prints the prompt tokens of each message and the total for
+// the whole conversation.
+func printTokenCounts(out io.Writer, messages []Message, tokenizer string) {
+	for i, msg := range messages {
+		count := tokensPerMessage + countTokens(string(msg.Role), tokenizer) + countTokens(msg.Content, tokenizer)
+		fmt.Fprintf(out, "[%d] %s: %d tokens\n", i, msg.Role, count)
+	}
+
+	count, exact := countMessagesTokens(messages, tokenizer)
+	if _, err := loadTokenizer(tokenizer); err != nil {
+		warnf(out, "Warning: %v", err)
+	}
+	if exact {
+		fmt.Fprintf(out, "Prompt: %d tokens in %d messages (%s)\n", count, len(messages), tokenizer)
+	} else {
+		fmt.Fprintf(out, "Prompt: ~%d tokens in %d messages (estimated)\n", count, len(messages))
+	}
+}
@@ -0,0 +1,51 @@
+package chat
+
+// LogRequests lists the IDs of the requests sent with --trace that an
+// assistant message of a conversation log was received from: the request
+// that produced it, followed by its continuations.
+type LogRequests struct {
+	// Message is the index of the assistant message in the log.
+	Message int      `json:"message"`
+	IDs     []string `json:"ids"`
+}
+
+// recordRequests records ids as the requests of the assistant message at
+// index, replacing those of an earlier reply at the same index.
+func recordRequests(requests []LogRequests, index int, ids []string) []LogRequests {
+	var recorded []LogRequests
+	for _, request := range requests {
+		if request.Message != index {
+			recorded = append(recorded, request)
+		}
+	}
+	if len(ids) > 0 {
+		recorded = append(recorded, LogRequests{Message: index, IDs: ids})
+	}
+	return recorded
+}
+
+// loggedRequests returns the requests of the assistant messages still in
+// messages, leaving out those of replies that were dropped since.
+func loggedRequests(requests []LogRequests, messages []Message) []LogRequests {
+	var logged []LogRequests
+	for _, request := range requests {
+		if request.Message < len(messages) && messages[request.Message].Role == ASSISTANT {
+			logged = append(logged, request)
+		}
+	}
+	return logged
+}
+
+// shiftRequests returns the requests of a conversation log once the messages
+// at the removed indices are dropped from it, keeping only those within its
+// count remaining messages.
+func shiftRequests(logRequests []LogRequests, removed []int, count int) []LogRequests {
+	var requests []LogRequests
+	for _, request := range logRequests {
+		request.Message = shiftIndex(request.Message, removed)
+		if request.Message < count {
+			requests = append(requests, request)
+		}
+	}
+	return requests
+}
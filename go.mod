@@ -2,4 +2,11 @@ module llm-chat-cli
 
 go 1.23.1
 
-require github.com/joho/godotenv v1.5.1
+require (
+	github.com/google/uuid v1.3.0
+	github.com/joho/godotenv v1.5.1
+	github.com/pkoukk/tiktoken-go v0.1.8
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require github.com/dlclark/regexp2 v1.10.0 // indirect